@@ -19,12 +19,27 @@ package v1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// 缩容时被删除的 pod 的 PVC 和 ConfigMap 的处理方式
+// +kubebuilder:validation:Enum=Retain;Delete
+type RetentionPolicy string
+
+const (
+	// 保留 PVC 和 ConfigMap，之后扩容时复用
+	RetentionPolicyRetain RetentionPolicy = "Retain"
+	// 删除 PVC 和 ConfigMap
+	RetentionPolicyDelete RetentionPolicy = "Delete"
 )
 
 // 存储
 type StorageConfig struct {
 	StorageClassName string `json:"storageClassName"`
 	Size             string `json:"size"`
+	// 缩容时 <name>-mysql-N 的 PVC 和 ConfigMap 是否保留
+	// +kubebuilder:default=Retain
+	RetentionPolicy RetentionPolicy `json:"retentionPolicy,omitempty"`
 }
 
 type BaseResource struct {
@@ -38,30 +53,604 @@ type ResourcesConfig struct {
 	Limits   BaseResource `json:"limits"`
 }
 
+// MySQL 账号密码配置
+type CredentialsConfig struct {
+	// 保存 root 密码和复制账号密码的 Secret 名字
+	// 为空时使用 <name>-credentials；Secret 不存在时由 operator 生成随机密码
+	// Secret 中的 key 为 root-password、replication-user、replication-password
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// 计划内主从切换
+type SwitchoverSpec struct {
+	// 要提升为新主库的从库 pod 名字
+	// 和 status.switchover.targetPod 不同时开始一次切换；要对同一个 pod 重试，先清空再重新设置
+	TargetPod string `json:"targetPod,omitempty"`
+	// 旧主库停写后等待目标从库追平的最长时间（秒），即写入中断的上限
+	// 超时后放弃切换，旧主库恢复写入
+	// +kubebuilder:default=30
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// 旧主库无法通过 sql 设置只读时的隔离方式
+// +kubebuilder:validation:Enum=Delete;NetworkPolicy
+type FencingPolicy string
+
+const (
+	// 删除旧主库 pod，PVC 保留，之后重建为从库
+	FencingPolicyDelete FencingPolicy = "Delete"
+	// 通过拒绝所有流量的 NetworkPolicy 隔离旧主库 pod，需要网络插件支持 NetworkPolicy
+	FencingPolicyNetworkPolicy FencingPolicy = "NetworkPolicy"
+)
+
+// 故障切换配置
+type FailoverSpec struct {
+	// 主库持续不可用多久（秒）后才开始故障切换，避免就绪探针抖动引起切换
+	// +kubebuilder:default=30
+	// +kubebuilder:validation:Minimum=1
+	GracePeriodSeconds int32 `json:"gracePeriodSeconds,omitempty"`
+	// 连续多少次检测到主库不可用后才开始故障切换
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+	// 旧主库连不上、无法设置 super_read_only 时的隔离方式
+	// +kubebuilder:default=Delete
+	FencingPolicy FencingPolicy `json:"fencingPolicy,omitempty"`
+}
+
+// 发现从库上有主库没有的事务（errant 事务）时的处理方式
+// +kubebuilder:validation:Enum=Report;InjectEmpty;Quarantine
+type ErrantTransactionPolicy string
+
+const (
+	// 只在 status 和 Event 中报告
+	ErrantTransactionPolicyReport ErrantTransactionPolicy = "Report"
+	// 在主库上注入同样 GTID 的空事务，让主从的 GTID 集合一致
+	ErrantTransactionPolicyInjectEmpty ErrantTransactionPolicy = "InjectEmpty"
+	// 隔离该从库，标记需要重新克隆
+	ErrantTransactionPolicyQuarantine ErrantTransactionPolicy = "Quarantine"
+)
+
+// 新从库的数据来源
+// +kubebuilder:validation:Enum=Xtrabackup;Clone;None
+type CloneMethod string
+
+const (
+	// 通过 xtrabackup 从 donor 的 sidecar 流式拷贝物理备份，适用于 MySQL 5.7
+	CloneMethodXtrabackup CloneMethod = "Xtrabackup"
+	// 通过 CLONE 插件从 donor 拷贝数据，需要 MySQL 8.0.17 以上
+	CloneMethodClone CloneMethod = "Clone"
+	// 不拷贝数据，新从库从空数据目录开始，要求主库保留全部 binlog
+	CloneMethodNone CloneMethod = "None"
+)
+
+// 新从库的克隆配置
+type CloneSpec struct {
+	// 新建或重新克隆的从库从 donor 拷贝数据的方式
+	// 默认 None，需要显式开启；Xtrabackup 会给所有 pod 加上 sidecar，已有集群开启时会滚动重启
+	// +kubebuilder:default=None
+	Method CloneMethod `json:"method,omitempty"`
+	// Xtrabackup 方式使用的镜像，需要包含 xtrabackup、xbstream 和 ncat，且 xtrabackup 版本和 MySQL 匹配
+	Image string `json:"image,omitempty"`
+	// 是否自动重新克隆因为 errant 事务被隔离的从库，重新克隆会清空它的数据目录
+	RecloneQuarantined bool `json:"recloneQuarantined,omitempty"`
+}
+
+// 主从复制方式
+// +kubebuilder:validation:Enum=async;semisync
+type ReplicationMode string
+
+const (
+	// 异步复制，故障切换时可能丢失主库已经提交的事务
+	ReplicationModeAsync ReplicationMode = "async"
+	// 半同步复制，主库提交前等待从库确认收到 binlog，超时后退化为异步
+	ReplicationModeSemisync ReplicationMode = "semisync"
+)
+
+// 主从复制配置
+type ReplicationSpec struct {
+	// +kubebuilder:default=async
+	Mode ReplicationMode `json:"mode,omitempty"`
+	// 半同步时主库提交前需要等待确认的从库数，即 rpl_semi_sync_master_wait_for_slave_count
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	WaitForSlaveCount int32 `json:"waitForSlaveCount,omitempty"`
+	// 半同步时主库等待从库确认的最长时间（秒），超时后退化为异步，即 rpl_semi_sync_master_timeout
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// 一组延迟从库
+type DelayedReplicaSpec struct {
+	// 延迟从库的个数
+	// +kubebuilder:validation:Minimum=1
+	Count int32 `json:"count"`
+	// 延迟应用主库事务的时间（秒），即 MASTER_DELAY
+	// +kubebuilder:validation:Minimum=1
+	DelaySeconds int32 `json:"delaySeconds"`
+}
+
+// 在指定事务之前停止延迟从库的 SQL 线程，用于在误操作被应用之前取出数据
+type DelayedReplicaStop struct {
+	// 延迟从库的 pod 名字
+	Pod string `json:"pod"`
+	// 停在这些事务之前，即 START SLAVE SQL_THREAD UNTIL SQL_BEFORE_GTIDS，例如误操作 DROP 的 gtid
+	BeforeGTIDSet string `json:"beforeGtidSet"`
+}
+
+// 一组级联从库，从中间从库而不是主库复制
+type ReplicaGroupSpec struct {
+	// 这一组从库的 pod 序号
+	// +kubebuilder:validation:MinItems=1
+	Ordinals []int32 `json:"ordinals"`
+	// 复制来源的 pod 序号，这个 pod 作为中间从库把 binlog 传给这一组从库
+	// 来源是主库、延迟从库或者不可用时，这一组从库改为从来源的上一级复制
+	// +kubebuilder:validation:Minimum=0
+	ReplicationSource int32 `json:"replicationSource"`
+}
+
+// 外部复制来源，迁移已有数据库时集群的主库先作为外部 MySQL 的从库
+// 外部 MySQL 需要开启 GTID，缺少的 binlog 需要事先导入数据并设置 gtid_purged
+type ExternalSourceSpec struct {
+	// 外部 MySQL 的地址
+	Host string `json:"host"`
+	// +kubebuilder:default=3306
+	Port int32 `json:"port,omitempty"`
+	// 外部 MySQL 复制账号所在的 Secret，key 为 replication-user 和 replication-password
+	SecretName string `json:"secretName"`
+	// 设为 true 后等主库应用完已经拉取的事务，停止从外部 MySQL 复制并开始接受写入，之后不能撤销
+	Promote bool `json:"promote,omitempty"`
+}
+
+// 集群拓扑
+// +kubebuilder:validation:Enum=MasterSlave;GroupReplication
+type Topology string
+
+const (
+	// 一主多从异步（或半同步）复制，主库挂掉后由 operator 选主
+	TopologyMasterSlave Topology = "MasterSlave"
+	// 单主模式的组复制，需要 MySQL 8.0，主库由组自己选举
+	TopologyGroupReplication Topology = "GroupReplication"
+)
+
 // YellowTangSpec defines the desired state of YellowTang
 type YellowTangSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	Image             string          `json:"image"`
-	NameSpace         string          `json:"namespace,omitempty"`
-	Replicas          int32           `json:"replicas,omitempty"`
-	MasterServiceName string          `json:"masterServiceName"`
-	SlaveServiceName  string          `json:"slaveServiceName"`
-	Storage           StorageConfig   `json:"storage"`
-	Resources         ResourcesConfig `json:"resources"`
-	ReadinessProbe    *corev1.Probe   `json:"readinessProbe,omitempty"`
-	LivenessProbe     *corev1.Probe   `json:"livenessProbe,omitempty"`
+	Image     string `json:"image"`
+	NameSpace string `json:"namespace,omitempty"`
+	Replicas  int32  `json:"replicas,omitempty"`
+	// 主库和从库 service 的名字，为空时分别为 <name>-master 和 <name>-slave
+	MasterServiceName string            `json:"masterServiceName,omitempty"`
+	SlaveServiceName  string            `json:"slaveServiceName,omitempty"`
+	Storage           StorageConfig     `json:"storage"`
+	Resources         ResourcesConfig   `json:"resources"`
+	ReadinessProbe    *corev1.Probe     `json:"readinessProbe,omitempty"`
+	LivenessProbe     *corev1.Probe     `json:"livenessProbe,omitempty"`
+	Credentials       CredentialsConfig `json:"credentials,omitempty"`
+	// pod 创建后等待就绪的最长时间（秒），超时后集群被标记为 Degraded
+	// +kubebuilder:default=300
+	// +kubebuilder:validation:Minimum=1
+	PodReadyTimeoutSeconds int32 `json:"podReadyTimeoutSeconds,omitempty"`
+	// 计划内主从切换，设置 targetPod 后把主库平滑切换到该从库
+	Switchover *SwitchoverSpec `json:"switchover,omitempty"`
+	// 故障切换的判定条件和旧主库的隔离方式
+	Failover FailoverSpec `json:"failover,omitempty"`
+	// 从库上发现 errant 事务时的处理方式
+	// +kubebuilder:default=Report
+	ErrantTransactionPolicy ErrantTransactionPolicy `json:"errantTransactionPolicy,omitempty"`
+	// 新从库从 donor 克隆数据的方式
+	Clone CloneSpec `json:"clone,omitempty"`
+	// 合并到 my.cnf [mysqld] 段的配置，覆盖 operator 的默认值，值为空表示不带值的选项
+	// server-id、GTID、binlog 和只读相关的配置由 operator 管理，不能修改
+	// 动态变量在线生效，静态变量按 spec.updateStrategy 重启 pod 生效
+	MySQLConfig map[string]string `json:"mysqlConfig,omitempty"`
+	// image、resources、探针等 pod 模板变化后更新 pod 的策略
+	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
+	// 主从复制方式，半同步时每次提升新主库后都会重新开启
+	Replication ReplicationSpec `json:"replication,omitempty"`
+	// 集群拓扑，创建后不能修改
+	// GroupReplication 时 spec.replication、spec.switchover、spec.failover 和账号密码轮换不生效
+	// +kubebuilder:default=MasterSlave
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="topology is immutable"
+	Topology Topology `json:"topology,omitempty"`
+	// 延迟从库，占用 spec.replicas 中编号最大的 pod，按顺序分配延迟时间
+	// 延迟从库不在 slave-service 中，也不会被选为新主库；GroupReplication 时不生效
+	DelayedReplicas []DelayedReplicaSpec `json:"delayedReplicas,omitempty"`
+	// 在指定事务之前停止延迟从库的 SQL 线程，删除后恢复复制
+	DelayedReplicaStops []DelayedReplicaStop `json:"delayedReplicaStops,omitempty"`
+	// 级联复制，不在任何一组中的从库从主库复制；GroupReplication 时不生效
+	ReplicaGroups []ReplicaGroupSpec `json:"replicaGroups,omitempty"`
+	// 从外部 MySQL 复制，promote 之前主库保持只读；GroupReplication 时不生效
+	ExternalSource *ExternalSourceSpec `json:"externalSource,omitempty"`
+}
+
+// pod 模板变化后更新 pod 的方式
+// +kubebuilder:validation:Enum=RollingUpdate;OnDelete
+type UpdateStrategyType string
+
+const (
+	// 先从库后主库逐个重建过期的 pod，主库先切换到已经更新的从库
+	UpdateStrategyRollingUpdate UpdateStrategyType = "RollingUpdate"
+	// 只在 status 中标记过期的 pod，由用户删除 pod 后按新模板重建
+	UpdateStrategyOnDelete UpdateStrategyType = "OnDelete"
+)
+
+// pod 更新策略，同时适用于 spec.mysqlConfig 中需要重启才能生效的配置
+type UpdateStrategy struct {
+	// +kubebuilder:default=RollingUpdate
+	Type UpdateStrategyType `json:"type,omitempty"`
+	// RollingUpdate 时最多同时不可用的从库数，可以是数字或者 spec.replicas 的百分比，默认为 1
+	// 主库总是在所有从库都可用后单独切换和重建
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// 集群所处的阶段
+// +kubebuilder:validation:Enum=Initializing;Running;Degraded;FailingOver;SwitchingOver;Failed
+type ClusterPhase string
+
+const (
+	ClusterPhaseInitializing  ClusterPhase = "Initializing"
+	ClusterPhaseRunning       ClusterPhase = "Running"
+	ClusterPhaseDegraded      ClusterPhase = "Degraded"
+	ClusterPhaseFailingOver   ClusterPhase = "FailingOver"
+	ClusterPhaseSwitchingOver ClusterPhase = "SwitchingOver"
+	ClusterPhaseFailed        ClusterPhase = "Failed"
+)
+
+// 状态条件类型
+const (
+	// 集群初始化是否完成
+	ConditionInitialized = "Initialized"
+	// 主库是否可用（master-service 是否有可用的 endpoint）
+	ConditionMasterAvailable = "MasterAvailable"
+	// 所有从库的 IO/SQL 线程是否正常
+	ConditionReplicationHealthy = "ReplicationHealthy"
+	// 所有 pod 是否已经就绪
+	ConditionPodsReady = "PodsReady"
+	// 账号密码是否已经和 Secret 一致
+	ConditionCredentialsSynced = "CredentialsSynced"
+	// 是否有因为 errant 事务被隔离、需要重新克隆的 pod
+	ConditionReplicasQuarantined = "ReplicasQuarantined"
+	// 所有从库是否都没有 errant 事务
+	ConditionReplicasConsistent = "ReplicasConsistent"
+	// spec.mysqlConfig 是否已经在所有 pod 上生效
+	ConditionConfigApplied = "ConfigApplied"
+	// 半同步复制时主库的半同步是否生效，没有退化为异步
+	ConditionSemisyncActive = "SemisyncActive"
+	// 主库从外部 MySQL 复制的 IO/SQL 线程是否正常
+	ConditionExternalReplication = "ExternalReplication"
+)
+
+// 初始化步骤，按顺序执行，每一步都可以重复执行
+// +kubebuilder:validation:Enum=Services;Configs;Storage;Pods;Replication;Done
+type InitStep string
+
+const (
+	InitStepServices    InitStep = "Services"
+	InitStepConfigs     InitStep = "Configs"
+	InitStepStorage     InitStep = "Storage"
+	InitStepPods        InitStep = "Pods"
+	InitStepReplication InitStep = "Replication"
+	InitStepDone        InitStep = "Done"
+)
+
+// 单个从库的复制状态
+type ReplicaStatus struct {
+	// 从库 pod 名字
+	Name string `json:"name"`
+	// Slave_IO_Running 是否为 Yes
+	IOThreadRunning bool `json:"ioThreadRunning"`
+	// Slave_SQL_Running 是否为 Yes
+	SQLThreadRunning bool `json:"sqlThreadRunning"`
+	// Seconds_Behind_Master，复制线程未运行时为空
+	SecondsBehindMaster *int64 `json:"secondsBehindMaster,omitempty"`
+	// Executed_Gtid_Set
+	ExecutedGtidSet string `json:"executedGtidSet,omitempty"`
+	// 最近一次 IO/SQL 线程错误或状态检测失败的原因
+	LastError string `json:"lastError,omitempty"`
+	// 从库执行过、主库上没有的事务
+	ErrantGtidSet string `json:"errantGtidSet,omitempty"`
+	// SQL_Delay，延迟从库的 MASTER_DELAY
+	DelaySeconds int32 `json:"delaySeconds,omitempty"`
+	// Master_Host，从主库复制时是 master-service，级联复制时是中间从库的 DNS 名字
+	Source string `json:"source,omitempty"`
+}
+
+// 延迟从库停止 SQL 线程的进度
+// +kubebuilder:validation:Enum=Stopping;Stopped;TooLate;Invalid
+type DelayedReplicaStopPhase string
+
+const (
+	// SQL 线程在等待应用到指定事务之前
+	DelayedReplicaStopStopping DelayedReplicaStopPhase = "Stopping"
+	// SQL 线程已经停在指定事务之前
+	DelayedReplicaStopStopped DelayedReplicaStopPhase = "Stopped"
+	// 延迟从库已经应用了指定事务，没有停止 SQL 线程
+	DelayedReplicaStopTooLate DelayedReplicaStopPhase = "TooLate"
+	// pod 不是延迟从库或者 gtid 集合无法解析
+	DelayedReplicaStopInvalid DelayedReplicaStopPhase = "Invalid"
+)
+
+// 一次延迟从库停止的状态
+type DelayedReplicaStopStatus struct {
+	// 延迟从库的 pod 名字
+	Pod string `json:"pod"`
+	// spec.delayedReplicaStops 中的事务
+	BeforeGTIDSet string                  `json:"beforeGtidSet"`
+	Phase         DelayedReplicaStopPhase `json:"phase"`
+	// 当前阶段的说明
+	Message string `json:"message,omitempty"`
+	// 开始停止的时间
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+}
+
+// 账号密码轮换状态
+type CredentialsStatus struct {
+	// 已经处理过的轮换代数，对应 annotation yellowtang.kaxonliu.com/credentials-rotation-generation
+	ObservedRotationGeneration int64 `json:"observedRotationGeneration,omitempty"`
+	// 最近一次完成轮换的时间
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+	// 主库上的密码已经修改，从库的 MASTER_PASSWORD 还没有全部更新
+	ReplicasPending bool `json:"replicasPending,omitempty"`
+	// 主库修改密码之后的 Executed_Gtid_Set，从库应用完它之后才更新 MASTER_PASSWORD
+	TargetGTIDSet string `json:"targetGTIDSet,omitempty"`
+	// 本次轮换中已经更新了 MASTER_PASSWORD 的从库
+	RepointedReplicas []string `json:"repointedReplicas,omitempty"`
+}
+
+// 主库 GTID 快照，主库挂掉后选主时用它衡量从库丢了多少事务
+type GTIDSnapshot struct {
+	// 采样的主库 pod 名字
+	Master string `json:"master"`
+	// 主库的 Executed_Gtid_Set
+	GTIDSet string `json:"gtidSet"`
+	// 采样时间
+	Time metav1.Time `json:"time"`
+}
+
+// 计划内切换的步骤，除 Completed 和 Failed 外控制器重启后都会从该步骤继续
+// +kubebuilder:validation:Enum=Fencing;WaitingForTarget;Promoting;Repointing;Completed;Failed
+type SwitchoverPhase string
+
+const (
+	// 旧主库设置 super_read_only，停止写入
+	SwitchoverPhaseFencing SwitchoverPhase = "Fencing"
+	// 等待目标从库执行完旧主库的所有 GTID
+	SwitchoverPhaseWaitingForTarget SwitchoverPhase = "WaitingForTarget"
+	// 提升目标从库并切换 master-service
+	SwitchoverPhasePromoting SwitchoverPhase = "Promoting"
+	// 其余从库和旧主库指向新主库
+	SwitchoverPhaseRepointing SwitchoverPhase = "Repointing"
+	SwitchoverPhaseCompleted  SwitchoverPhase = "Completed"
+	SwitchoverPhaseFailed     SwitchoverPhase = "Failed"
+)
+
+// 发起切换的原因
+// +kubebuilder:validation:Enum=Requested;RollingRestart
+type SwitchoverReason string
+
+const (
+	// 通过 spec.switchover.targetPod 请求的切换
+	SwitchoverReasonRequested SwitchoverReason = "Requested"
+	// 滚动重启到主库时，先把主库切到已经重启过的从库
+	SwitchoverReasonRollingRestart SwitchoverReason = "RollingRestart"
+)
+
+// 计划内切换的进度
+type SwitchoverStatus struct {
+	// 要提升的从库
+	TargetPod string `json:"targetPod"`
+	// 发起切换的原因，为空表示 Requested
+	Reason SwitchoverReason `json:"reason,omitempty"`
+	// 最近一次处理过的 spec.switchover.targetPod，operator 自己发起的切换沿用上一次的值
+	RequestedTargetPod string `json:"requestedTargetPod,omitempty"`
+	// 切换前的主库
+	OldMaster string `json:"oldMaster,omitempty"`
+	// 当前步骤
+	Phase SwitchoverPhase `json:"phase"`
+	// 当前步骤的说明或失败原因
+	Message string `json:"message,omitempty"`
+	// 开始切换的时间
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// 旧主库停写的时间
+	FencedTime *metav1.Time `json:"fencedTime,omitempty"`
+	// 切换完成或失败的时间
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// 主库不可用的检测记录，主库恢复后清空
+type MasterFailureStatus struct {
+	// 第一次检测到主库不可用的时间
+	Since metav1.Time `json:"since"`
+	// 最近一次检测到主库不可用的时间
+	LastProbeTime metav1.Time `json:"lastProbeTime"`
+	// 连续检测到主库不可用的次数
+	ConsecutiveFailures int32 `json:"consecutiveFailures"`
+}
+
+// 旧主库的隔离方式
+// +kubebuilder:validation:Enum=ReadOnly;Deleted;NetworkPolicy
+type FencingMethod string
+
+const (
+	// 通过 sql 设置了 super_read_only
+	FencingMethodReadOnly FencingMethod = "ReadOnly"
+	// pod 已经被删除
+	FencingMethodDeleted FencingMethod = "Deleted"
+	// pod 被 NetworkPolicy 隔离
+	FencingMethodNetworkPolicy FencingMethod = "NetworkPolicy"
+)
+
+// 被隔离的旧主库
+type FencedPod struct {
+	// pod 名字
+	Name string `json:"name"`
+	// 隔离方式
+	Method FencingMethod `json:"method"`
+	// 隔离时间
+	Time metav1.Time `json:"time"`
+	// 重新加入时发现的、新主库上没有的事务
+	ErrantGtidSet string `json:"errantGtidSet,omitempty"`
+	// 有 errant 事务，不能直接作为从库加入，需要重新克隆数据
+	NeedsReclone bool `json:"needsReclone,omitempty"`
+}
+
+// 克隆的步骤
+// +kubebuilder:validation:Enum=Cloning;Restoring;Completed;Failed
+type ClonePhase string
+
+const (
+	// clone init 容器正在从 donor 拷贝数据
+	ClonePhaseCloning ClonePhase = "Cloning"
+	// 数据已经拷贝完，等待 MySQL 启动并恢复 GTID
+	ClonePhaseRestoring ClonePhase = "Restoring"
+	ClonePhaseCompleted ClonePhase = "Completed"
+	ClonePhaseFailed    ClonePhase = "Failed"
+)
+
+// 从库克隆的进度
+type CloneStatus struct {
+	// 被克隆的 pod
+	Pod string `json:"pod"`
+	// 提供数据的 pod
+	Donor string `json:"donor"`
+	// 克隆方式
+	Method CloneMethod `json:"method"`
+	// 当前步骤
+	Phase ClonePhase `json:"phase"`
+	// 当前步骤的说明或失败原因
+	Message string `json:"message,omitempty"`
+	// 克隆得到的数据对应的 GTID 集合，复制从这里开始
+	GTIDSet string `json:"gtidSet,omitempty"`
+	// clone init 容器失败重启的次数
+	Restarts int32 `json:"restarts,omitempty"`
+	// 开始克隆的时间
+	StartTime metav1.Time `json:"startTime"`
+	// 克隆完成或失败的时间
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// 镜像升级的步骤
+// +kubebuilder:validation:Enum=Upgrading;Paused;Completed
+type UpgradePhase string
+
+const (
+	// 正在按先从库后主库的顺序逐个重建 pod
+	UpgradePhaseUpgrading UpgradePhase = "Upgrading"
+	// 集群没有通过健康检查，等集群恢复后继续
+	UpgradePhasePaused    UpgradePhase = "Paused"
+	UpgradePhaseCompleted UpgradePhase = "Completed"
+)
+
+// 镜像升级的进度
+type UpgradeStatus struct {
+	// 目标镜像
+	Image string `json:"image"`
+	// 当前步骤
+	Phase UpgradePhase `json:"phase"`
+	// 还在使用旧镜像的 pod
+	PendingPods []string `json:"pendingPods,omitempty"`
+	// 当前动作或者暂停的原因
+	Message string `json:"message,omitempty"`
+	// 开始升级的时间
+	StartTime metav1.Time `json:"startTime"`
+	// 升级完成的时间
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// 半同步复制状态，从主库的状态变量读取
+type SemisyncStatus struct {
+	// 主库的半同步是否生效，等待从库确认超时退化为异步时为 false（Rpl_semi_sync_master_status）
+	MasterActive bool `json:"masterActive"`
+	// 开启了半同步的从库连接数（Rpl_semi_sync_master_clients）
+	Clients int32 `json:"clients"`
+	// 最近一次退化为异步的时间
+	LastFallbackTime *metav1.Time `json:"lastFallbackTime,omitempty"`
+}
+
+// 主库从外部 MySQL 复制的状态，从主库的 SHOW SLAVE STATUS 读取
+type ExternalSourceStatus struct {
+	// 外部 MySQL 的地址
+	Host string `json:"host"`
+	// Slave_IO_Running 是否为 Yes
+	IOThreadRunning bool `json:"ioThreadRunning"`
+	// Slave_SQL_Running 是否为 Yes
+	SQLThreadRunning bool `json:"sqlThreadRunning"`
+	// 主库落后外部 MySQL 的秒数（Seconds_Behind_Master），复制线程未运行时为空
+	SecondsBehindSource *int64 `json:"secondsBehindSource,omitempty"`
+	// 最近一次 IO/SQL 线程错误
+	LastError string `json:"lastError,omitempty"`
+	// 是否已经停止从外部 MySQL 复制
+	Promoted bool `json:"promoted,omitempty"`
+	// 停止从外部 MySQL 复制的时间
+	PromotedTime *metav1.Time `json:"promotedTime,omitempty"`
+}
+
+// 组复制成员状态，来自 performance_schema.replication_group_members
+type GroupMemberStatus struct {
+	// pod 名字
+	Name string `json:"name"`
+	// MEMBER_STATE：ONLINE、RECOVERING、OFFLINE、ERROR、UNREACHABLE，不在组中的 pod 为 OFFLINE
+	State string `json:"state"`
+	// MEMBER_ROLE：PRIMARY 或 SECONDARY
+	Role string `json:"role,omitempty"`
 }
 
 // YellowTangStatus defines the observed state of YellowTang
 type YellowTangStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// 集群当前阶段
+	Phase ClusterPhase `json:"phase,omitempty"`
+	// 最近一次调谐时看到的 metadata.generation
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// 初始化进行到的步骤，控制器重启后从这一步继续
+	InitStep InitStep `json:"initStep,omitempty"`
+	// 当前主库 pod 名字
+	MasterPod string `json:"masterPod,omitempty"`
+	// 各个从库的复制状态
+	Replicas []ReplicaStatus `json:"replicas,omitempty"`
+	// 账号密码轮换状态
+	Credentials *CredentialsStatus `json:"credentials,omitempty"`
+	// 最近一次采样到的主库 GTID
+	MasterGTIDSnapshot *GTIDSnapshot `json:"masterGTIDSnapshot,omitempty"`
+	// 最近一次计划内切换的进度
+	Switchover *SwitchoverStatus `json:"switchover,omitempty"`
+	// 主库不可用的检测记录
+	MasterFailure *MasterFailureStatus `json:"masterFailure,omitempty"`
+	// 被隔离、还没有重新加入集群的 pod：故障切换时的旧主库，以及因为 errant 事务被隔离的从库
+	FencedPods []FencedPod `json:"fencedPods,omitempty"`
+	// 每个 pod 最近一次克隆的进度
+	Clones []CloneStatus `json:"clones,omitempty"`
+	// 最近一次镜像升级的进度
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+	// pod 模板 hash 和当前 spec 不一致、等待重建的 pod
+	OutdatedPods []string `json:"outdatedPods,omitempty"`
+	// 过期的 pod 数
+	OutdatedReplicas int32 `json:"outdatedReplicas,omitempty"`
+	// 半同步复制状态，异步复制时为空
+	Semisync *SemisyncStatus `json:"semisync,omitempty"`
+	// 组复制时每个 pod 的成员状态
+	GroupMembers []GroupMemberStatus `json:"groupMembers,omitempty"`
+	// spec.delayedReplicaStops 的进度
+	DelayedReplicaStops []DelayedReplicaStopStatus `json:"delayedReplicaStops,omitempty"`
+	// 从外部 MySQL 复制的状态，没有设置 spec.externalSource 时为空
+	ExternalSource *ExternalSourceStatus `json:"externalSource,omitempty"`
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Master",type="string",JSONPath=".status.masterPod"
+// +kubebuilder:printcolumn:name="Replicas",type="integer",JSONPath=".spec.replicas"
+// +kubebuilder:printcolumn:name="Outdated",type="integer",JSONPath=".status.outdatedReplicas"
+// +kubebuilder:printcolumn:name="Source-Lag",type="integer",JSONPath=".status.externalSource.secondsBehindSource",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // YellowTang is the Schema for the yellowtangs API
 type YellowTang struct {
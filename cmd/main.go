@@ -58,6 +58,7 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var sqlBackend string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -69,6 +70,9 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&sqlBackend, "sql-backend", controller.SQLBackendTCP,
+		"How the controller runs SQL against MySQL pods: tcp connects to the pod IP directly, "+
+			"exec runs the mysql client inside the pod.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -145,8 +149,10 @@ func main() {
 	}
 
 	if err = (&controller.YellowTangReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		Recorder:   mgr.GetEventRecorderFor("yellowtang-controller"),
+		SQLBackend: sqlBackend,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "YellowTang")
 		os.Exit(1)
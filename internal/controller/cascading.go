@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+// spec.replicaGroups 中 pod 的复制来源序号，不在任何一组中时返回 false
+func configuredSourceOrdinal(ordinal int, tang *appsv1.YellowTang) (int, bool) {
+	for _, group := range tang.Spec.ReplicaGroups {
+		for _, o := range group.Ordinals {
+			if int(o) == ordinal {
+				return int(group.ReplicationSource), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// 可以作为中间从库的 pod：就绪、没有被隔离、不在克隆或缩容中、不是延迟从库，并且自己的复制正常
+func (r *YellowTangReconciler) getCascadingSources(ctx context.Context, tang *appsv1.YellowTang) (map[string]bool, error) {
+	sources := map[string]bool{}
+	if len(tang.Spec.ReplicaGroups) == 0 {
+		return sources, nil
+	}
+	pods, err := r.getPodByLabels(clusterLabels(tang), ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+
+	replicating := map[string]bool{}
+	for _, replica := range tang.Status.Replicas {
+		replicating[replica.Name] = replica.IOThreadRunning && replica.SQLThreadRunning
+	}
+	for i := range pods {
+		pod := &pods[i]
+		if !isPodHealthy(*pod) || isFencedPod(pod, tang) || cloneInProgress(tang, pod.Name) ||
+			pod.Labels["role"] == roleRemoving || isDelayedReplica(pod.Name, tang) {
+			continue
+		}
+		sources[pod.Name] = replicating[pod.Name]
+	}
+	return sources, nil
+}
+
+// 从库的 MASTER_HOST
+// 按 spec.replicaGroups 沿着复制来源往上找，来源不能作为中间从库时改为它的上一级，最上面是 master-service
+// 来源是主库或者出现环时直接从主库复制
+func replicationSourceHost(podName, masterPodName string, sources map[string]bool, tang *appsv1.YellowTang) string {
+	visited := map[string]bool{podName: true}
+	name := podName
+	for {
+		ordinal, ok := parsePodNo(name, tang)
+		if !ok {
+			break
+		}
+		sourceOrdinal, ok := configuredSourceOrdinal(ordinal, tang)
+		if !ok {
+			break
+		}
+		source := mysqlPodName(tang, sourceOrdinal)
+		if source == masterPodName || visited[source] {
+			break
+		}
+		if sources[source] {
+			return podFQDN(source, tang)
+		}
+		visited[source] = true
+		name = source
+	}
+	return masterServiceName(tang)
+}
+
+// 复制正常、但复制来源和 spec.replicaGroups 不一致的从库
+// 中间从库挂掉后下游从库先改为从上一级复制，中间从库恢复后再改回来
+func (r *YellowTangReconciler) getMisdirectedReplicas(ctx context.Context, masterPodName string, slavePods []corev1.Pod, failed []string, tang *appsv1.YellowTang) ([]string, error) {
+	sources, err := r.getCascadingSources(ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+	skip := map[string]bool{}
+	for _, name := range failed {
+		skip[name] = true
+	}
+	current := map[string]string{}
+	for _, replica := range tang.Status.Replicas {
+		if replica.IOThreadRunning {
+			current[replica.Name] = replica.Source
+		}
+	}
+
+	misdirected := []string{}
+	for _, pod := range slavePods {
+		source, ok := current[pod.Name]
+		if !ok || skip[pod.Name] || source == replicationSourceHost(pod.Name, masterPodName, sources, tang) {
+			continue
+		}
+		misdirected = append(misdirected, pod.Name)
+	}
+	return misdirected, nil
+}
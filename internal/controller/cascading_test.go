@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+func TestReplicationSourceHost(t *testing.T) {
+	tang := &appsv1.YellowTang{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "db"},
+		Spec:       appsv1.YellowTangSpec{Replicas: 6},
+	}
+	master := masterServiceName(tang)
+	pod := func(ordinal int) string { return mysqlPodName(tang, ordinal) }
+	host := func(ordinal int) string { return podFQDN(pod(ordinal), tang) }
+
+	tests := []struct {
+		name    string
+		groups  []appsv1.ReplicaGroupSpec
+		master  string
+		sources map[string]bool
+		pod     string
+		want    string
+	}{
+		{
+			name:   "no replica groups",
+			master: pod(0),
+			pod:    pod(1),
+			want:   master,
+		},
+		{
+			name:    "not in any group",
+			groups:  []appsv1.ReplicaGroupSpec{{Ordinals: []int32{3}, ReplicationSource: 1}},
+			master:  pod(0),
+			sources: map[string]bool{pod(1): true},
+			pod:     pod(2),
+			want:    master,
+		},
+		{
+			name:    "healthy intermediate",
+			groups:  []appsv1.ReplicaGroupSpec{{Ordinals: []int32{2, 3}, ReplicationSource: 1}},
+			master:  pod(0),
+			sources: map[string]bool{pod(1): true},
+			pod:     pod(3),
+			want:    host(1),
+		},
+		{
+			name:    "dead intermediate falls back to master-service",
+			groups:  []appsv1.ReplicaGroupSpec{{Ordinals: []int32{2, 3}, ReplicationSource: 1}},
+			master:  pod(0),
+			sources: map[string]bool{},
+			pod:     pod(3),
+			want:    master,
+		},
+		{
+			name:    "intermediate with broken replication falls back to master-service",
+			groups:  []appsv1.ReplicaGroupSpec{{Ordinals: []int32{2}, ReplicationSource: 1}},
+			master:  pod(0),
+			sources: map[string]bool{pod(1): false},
+			pod:     pod(2),
+			want:    master,
+		},
+		{
+			name: "dead intermediate falls back to the next level",
+			groups: []appsv1.ReplicaGroupSpec{
+				{Ordinals: []int32{2}, ReplicationSource: 1},
+				{Ordinals: []int32{4}, ReplicationSource: 2},
+			},
+			master:  pod(0),
+			sources: map[string]bool{pod(1): true},
+			pod:     pod(4),
+			want:    host(1),
+		},
+		{
+			name:    "source is the master",
+			groups:  []appsv1.ReplicaGroupSpec{{Ordinals: []int32{2}, ReplicationSource: 1}},
+			master:  pod(1),
+			sources: map[string]bool{pod(1): true},
+			pod:     pod(2),
+			want:    master,
+		},
+		{
+			name: "cycle falls back to master-service",
+			groups: []appsv1.ReplicaGroupSpec{
+				{Ordinals: []int32{2}, ReplicationSource: 3},
+				{Ordinals: []int32{3}, ReplicationSource: 2},
+			},
+			master:  pod(0),
+			sources: map[string]bool{},
+			pod:     pod(2),
+			want:    master,
+		},
+		{
+			name:    "replicating from itself",
+			groups:  []appsv1.ReplicaGroupSpec{{Ordinals: []int32{2}, ReplicationSource: 2}},
+			master:  pod(0),
+			sources: map[string]bool{pod(2): true},
+			pod:     pod(2),
+			want:    master,
+		},
+		{
+			name:    "pod of another cluster",
+			groups:  []appsv1.ReplicaGroupSpec{{Ordinals: []int32{2}, ReplicationSource: 1}},
+			master:  pod(0),
+			sources: map[string]bool{pod(1): true},
+			pod:     "other-mysql-2",
+			want:    master,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tang.Spec.ReplicaGroups = tt.groups
+			if got := replicationSourceHost(tt.pod, tt.master, tt.sources, tang); got != tt.want {
+				t.Errorf("replicationSourceHost(%s) = %s, want %s", tt.pod, got, tt.want)
+			}
+		})
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"strings"
 	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/mysql"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -18,7 +21,7 @@ import (
 func (r *YellowTangReconciler) checkMasterStatus(ctx context.Context, tang *appsv1.YellowTang) (bool, string, error) {
 	// 获取 master-service 关联的 Endpoints
 	endpoints := corev1.Endpoints{}
-	endpointKey := client.ObjectKey{Name: tang.Spec.MasterServiceName, Namespace: tang.Namespace}
+	endpointKey := client.ObjectKey{Name: masterServiceName(tang), Namespace: tang.Namespace}
 	if err := r.Get(ctx, endpointKey, &endpoints); err != nil {
 		return false, "", err
 	}
@@ -28,7 +31,15 @@ func (r *YellowTangReconciler) checkMasterStatus(ctx context.Context, tang *apps
 		return false, "", nil
 	}
 
+	// 旧主库带着 master 标签回来时 endpoint 中可能有多个地址，优先认 status 中记录的主库
 	masterPodName := endpoints.Subsets[0].Addresses[0].TargetRef.Name
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.TargetRef != nil && address.TargetRef.Name == tang.Status.MasterPod {
+				return true, tang.Status.MasterPod, nil
+			}
+		}
+	}
 	return true, masterPodName, nil
 }
 
@@ -38,14 +49,49 @@ func (r *YellowTangReconciler) handleMasterFailure(ctx context.Context, tang *ap
 	logger := log.FromContext(ctx)
 	logger.Info("开始处理出库挂掉的情况...")
 
+	// 先把故障切换中的状态写回去，切换期间通过 kubectl 即可看到
+	tang.Status.Phase = appsv1.ClusterPhaseFailingOver
+	setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionFalse, "MasterDown", "master-service has no ready endpoint")
+	if err := r.updateStatus(ctx, tang); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// 提升新主库之前先隔离旧主库，隔离失败不能切换，否则可能出现两个可写的主库
+	if err := r.fenceFailedMaster(ctx, tang); err != nil {
+		tang.Status.Phase = appsv1.ClusterPhaseFailed
+		setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionFalse, "FencingFailed", err.Error())
+		if statusErr := r.updateStatus(ctx, tang); statusErr != nil {
+			logger.Error(statusErr, "更新集群状态失败")
+		}
+		return ctrl.Result{}, err
+	}
+
 	// 选举新的主库（假设选举逻辑已经实现）
 	newMasterName, remainingSlaves, err := r.electNewMaster(ctx, tang)
 	if err != nil {
+		tang.Status.Phase = appsv1.ClusterPhaseFailed
+		setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionFalse, "ElectionFailed", err.Error())
+		if statusErr := r.updateStatus(ctx, tang); statusErr != nil {
+			logger.Error(statusErr, "更新集群状态失败")
+		}
 		return ctrl.Result{}, err
 	}
 
 	// 重新配置主从关系
 	if err := r.setupMasterSlaveReplication(ctx, newMasterName, remainingSlaves, tang); err != nil {
+		tang.Status.Phase = appsv1.ClusterPhaseFailed
+		setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionFalse, "PromotionFailed", err.Error())
+		if statusErr := r.updateStatus(ctx, tang); statusErr != nil {
+			logger.Error(statusErr, "更新集群状态失败")
+		}
+		return ctrl.Result{}, err
+	}
+
+	tang.Status.Phase = appsv1.ClusterPhaseRunning
+	tang.Status.MasterPod = newMasterName
+	tang.Status.MasterFailure = nil
+	setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionTrue, "MasterPromoted", fmt.Sprintf("%s promoted to master", newMasterName))
+	if err := r.updateStatus(ctx, tang); err != nil {
 		return ctrl.Result{}, err
 	}
 
@@ -65,7 +111,7 @@ func (r *YellowTangReconciler) checkSlaveStatus(masterPodName string, ctx contex
 	// 筛选出来所有的从pod
 	allSlavePodList := []corev1.Pod{}
 
-	allPodList, err := r.getPodByLabels(map[string]string{"tang": "true", "app": "mysql"}, ctx, tang)
+	allPodList, err := r.getPodByLabels(clusterLabels(tang), ctx, tang)
 	if err != nil {
 		return allSlavePodList, failedSlavePodList, fmt.Errorf("failed to get all pod %v", err)
 	}
@@ -74,7 +120,9 @@ func (r *YellowTangReconciler) checkSlaveStatus(masterPodName string, ctx contex
 	_allSlavePodNameList := []string{}
 	for _, pod := range allPodList {
 		_allPodNameList = append(_allPodNameList, pod.Name)
-		if pod.Name != masterPodName {
+		// 被隔离的旧主库由 reintegrateFencedPods 处理，不作为从库修复
+		// 还没有恢复完 GTID 的克隆从库由 reconcileClones 处理
+		if pod.Name != masterPodName && !isFencedPod(&pod, tang) && !cloneInProgress(tang, pod.Name) {
 			allSlavePodList = append(allSlavePodList, pod)
 			_allSlavePodNameList = append(_allSlavePodNameList, pod.Name)
 		}
@@ -83,28 +131,46 @@ func (r *YellowTangReconciler) checkSlaveStatus(masterPodName string, ctx contex
 	log.Info("所有从库pod", "Pod", _allSlavePodNameList)
 
 	// 筛选出来主从状态异常的从pod
-	// 准备 SQL 查询命令
-	sqlQuery := fmt.Sprintf("mysql -uroot -p%s -e \"SHOW SLAVE STATUS \\G\"", MySQLPassword)
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return allSlavePodList, failedSlavePodList, err
+	}
 
+	replicaStatuses := []appsv1.ReplicaStatus{}
 	for _, pod := range allSlavePodList {
 		// 执行 SQL 查询
-		output, err := r.execCommandOnPod(&pod, sqlQuery)
+		replica, err := r.getReplicaStatus(ctx, &pod, creds)
 		if err != nil {
 			log.Info("从库状态检测失败", "Pod", pod.Name, "错误", err)
 			failedSlavePodList = append(failedSlavePodList, pod)
+			replicaStatuses = append(replicaStatuses, appsv1.ReplicaStatus{Name: pod.Name, LastError: err.Error()})
 			continue
 		}
 
 		// 解析 SQL 查询结果
-		sqlThread := strings.Contains(output, "Slave_SQL_Running: Yes")
-		ioThread := strings.Contains(output, "Slave_IO_Running: Yes")
+		replicaStatus := replicaStatusFromMySQL(pod.Name, replica)
+		if replica == nil {
+			// 还没有配置复制的从库也要检查 errant 事务
+			if executed, err := mysql.GetExecutedGTIDSet(ctx, r.SQL, rootTarget(&pod, creds.RootPassword)); err == nil {
+				replicaStatus.ExecutedGtidSet = executed
+			}
+		}
+		replicaStatuses = append(replicaStatuses, replicaStatus)
 
-		if !(sqlThread && ioThread) {
-			log.Info("从库状态检测失败", "Pod", pod.Name, "错误", "从库状态的返回字段匹配失败")
+		// 按 spec.delayedReplicaStops 停止 SQL 线程的延迟从库只要求 IO 线程运行
+		if !(replicaStatus.SQLThreadRunning && replicaStatus.IOThreadRunning) &&
+			!(replicaStatus.IOThreadRunning && delayedReplicaStopActive(pod.Name, tang)) {
+			log.Info("从库状态检测失败", "Pod", pod.Name, "错误", "从库 IO/SQL 线程未运行")
 			failedSlavePodList = append(failedSlavePodList, pod)
 		}
 	}
 
+	// 从库的 GTID 读完之后再读主库的，主库在这期间提交的事务不会被误判为 errant
+	if err := r.computeErrantGTIDSets(ctx, masterPodName, replicaStatuses, creds, tang); err != nil {
+		log.Info("errant 事务检测失败", "错误", err)
+	}
+	tang.Status.Replicas = replicaStatuses
+
 	_failedSlavePodNameList := []string{}
 	for _, pod := range failedSlavePodList {
 		_failedSlavePodNameList = append(_failedSlavePodNameList, pod.Name)
@@ -123,6 +189,18 @@ func (r *YellowTangReconciler) checkCluster(ctx context.Context, tang *appsv1.Ye
 	logger := log.FromContext(ctx)
 	logger.Info("开始集群主从状态检测...")
 
+	// 组复制由组自己选主，不走 master-service 的检查和 electNewMaster
+	if topology(tang) == appsv1.TopologyGroupReplication {
+		return r.checkGroup(ctx, tang)
+	}
+
+	result := ctrl.Result{}
+
+	// 有执行到一半的计划内切换时先把它做完，切换过程中 master-service 可能暂时没有 endpoint
+	if switchoverInProgress(tang) {
+		return r.switchover(ctx, tang.Status.Switchover.OldMaster, tang)
+	}
+
 	// 检查主库是否挂掉
 	masterAlive, masterPodName, err := r.checkMasterStatus(ctx, tang)
 	if err != nil {
@@ -130,34 +208,168 @@ func (r *YellowTangReconciler) checkCluster(ctx context.Context, tang *appsv1.Ye
 	}
 
 	if !masterAlive {
+		// 主库不可用，持续超过宽限期且连续失败次数达到阈值后才故障切换
+		if !recordMasterFailure(tang) {
+			failure := tang.Status.MasterFailure
+			logger.Info("主库不可用，等待确认", "连续失败次数", failure.ConsecutiveFailures, "开始时间", failure.Since.Time)
+			tang.Status.Phase = appsv1.ClusterPhaseDegraded
+			setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionFalse, "MasterUnreachable",
+				fmt.Sprintf("master-service has no ready endpoint, failure %d/%d, grace period %s",
+					failure.ConsecutiveFailures, failoverFailureThreshold(tang), failoverGracePeriod(tang)))
+			if err := r.updateStatus(ctx, tang); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: failoverProbeInterval}, nil
+		}
+
 		// 主库挂了
 		if result, err := r.handleMasterFailure(ctx, tang); err != nil {
 			// 如果处理主库故障时出现错误，则返回错误并重新排队调谐
 			return result, err
 		}
 	} else {
+		tang.Status.MasterPod = masterPodName
+		tang.Status.MasterFailure = nil
+		setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionTrue, "MasterReady", fmt.Sprintf("%s is serving %s", masterPodName, masterServiceName(tang)))
+
+		// spec.switchover 请求了新的计划内切换
+		if switchoverRequested(tang) {
+			return r.switchover(ctx, masterPodName, tang)
+		}
+
+		// 旧主库带着 master 标签回来时先隔离，再检查能否作为从库重新加入
+		if err := r.fenceStrayMasters(ctx, masterPodName, tang); err != nil {
+			logger.Error(err, "隔离旧主库失败")
+			result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+		}
+		if err := r.reintegrateFencedPods(ctx, masterPodName, tang); err != nil {
+			logger.Error(err, "旧主库重新加入失败")
+			result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+		}
+
+		// 克隆出来的从库先恢复 GTID，之后才能作为从库配置复制
+		if err := r.reconcileClones(ctx, tang); err != nil {
+			logger.Error(err, "克隆处理失败")
+			result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+		}
+
+		// Secret 中的账号密码有变化时先完成轮换
+		// 轮换失败不影响后面的从库检查，稍后重新排队再试
+		rotationResult, err := r.reconcileCredentialRotation(ctx, masterPodName, tang)
+		if err != nil {
+			logger.Error(err, "账号密码轮换失败")
+			rotationResult = ctrl.Result{RequeueAfter: podReadyRequeueInterval}
+		}
+		result = mergeResult(result, rotationResult)
+
 		// 主库OK,检查从库
+		previousErrant := errantGTIDSetsByPod(tang)
 		allSlavePodList, failedSlavePodList, err := r.checkSlaveStatus(masterPodName, ctx, tang)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 
+		// 处理 errant 事务，按策略被隔离的从库不再作为从库修复
+		if err := r.handleErrantTransactions(ctx, masterPodName, previousErrant, tang); err != nil {
+			logger.Error(err, "处理 errant 事务失败")
+			result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+		}
+		allSlavePodList = withoutFencedPods(allSlavePodList, tang)
+		failedSlavePodList = withoutFencedPods(failedSlavePodList, tang)
+		setQuarantineCondition(tang)
+
+		// 延迟从库的 MASTER_DELAY 和 spec.delayedReplicaStops
+		if err := r.reconcileReplicaDelays(ctx, allSlavePodList, tang); err != nil {
+			logger.Error(err, "修改从库延迟失败")
+			result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+		}
+		if err := r.reconcileDelayedReplicaStops(ctx, masterPodName, tang); err != nil {
+			logger.Error(err, "停止延迟从库失败")
+			result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+		}
+		for _, stop := range tang.Status.DelayedReplicaStops {
+			if stop.Phase == appsv1.DelayedReplicaStopStopping {
+				result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+			}
+		}
+
 		// 重新配置失败的从库
 		failedSlavePodNameList := []string{}
 		for _, pod := range failedSlavePodList {
 			failedSlavePodNameList = append(failedSlavePodNameList, pod.Name)
 		}
+		if len(failedSlavePodNameList) == 0 {
+			setCondition(tang, appsv1.ConditionReplicationHealthy, metav1.ConditionTrue, "ReplicasInSync", "all replicas are replicating")
+		} else {
+			setCondition(tang, appsv1.ConditionReplicationHealthy, metav1.ConditionFalse, "ReplicasBroken", fmt.Sprintf("replication broken on %s", strings.Join(failedSlavePodNameList, ",")))
+		}
+		// pod 超过就绪期限仍未就绪、有 errant 事务或者有被隔离的 pod 时保持 Degraded
+		if len(failedSlavePodNameList) == 0 && !podsReadyTimedOut(tang) &&
+			len(errantGTIDSetsByPod(tang)) == 0 && len(quarantinedPods(tang)) == 0 {
+			tang.Status.Phase = appsv1.ClusterPhaseRunning
+		} else {
+			tang.Status.Phase = appsv1.ClusterPhaseDegraded
+		}
+		if err := r.updateStatus(ctx, tang); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		// 复制来源和 spec.replicaGroups 不一致的从库和失败的从库一起重新配置
+		repointSlavePodNameList, err := r.getMisdirectedReplicas(ctx, masterPodName, allSlavePodList, failedSlavePodNameList, tang)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(repointSlavePodNameList) > 0 {
+			logger.Info("从库的复制来源和级联配置不一致，重新指向", "Pod", repointSlavePodNameList)
+		}
+
 		// 避免重复设置主库
-		if len(failedSlavePodNameList) >= 1 {
-			if err := r.setupMasterSlaveReplication(ctx, masterPodName, failedSlavePodNameList, tang); err != nil {
+		if setupSlavePodNameList := append(failedSlavePodNameList, repointSlavePodNameList...); len(setupSlavePodNameList) >= 1 {
+			if err := r.setupMasterSlaveReplication(ctx, masterPodName, setupSlavePodNameList, tang); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
-		// 确保所有的从Pod都有标签 role=slave
+		// 确保所有的从Pod都有标签 role=slave，延迟从库为 role=delayed
 		for _, pod := range allSlavePodList {
-			r.labelPod(&pod, "slave", ctx, tang)
+			r.labelPod(&pod, slaveRole(pod.Name, tang), ctx, tang)
+		}
+		// 确保所有的从库都开启了 super_read_only
+		if err := r.enforceSlaveReadOnly(ctx, allSlavePodList, tang); err != nil {
+			logger.Error(err, "从库开启只读失败")
+			result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+		}
+
+		// 半同步配置和状态、spec.mysqlConfig 在线生效，需要重启的配置和 pod 模板的变化一起滚动重启
+		// 状态有变化时再写回一次
+		statusBefore := tang.Status.DeepCopy()
+		externalResult, err := r.reconcileExternalSource(ctx, masterPodName, tang)
+		if err != nil {
+			logger.Error(err, "从外部 MySQL 复制失败")
+			externalResult = ctrl.Result{RequeueAfter: podReadyRequeueInterval}
+		}
+		result = mergeResult(result, externalResult)
+		if err := r.reconcileSemisync(ctx, masterPodName, allSlavePodList, tang); err != nil {
+			logger.Error(err, "半同步配置失败")
+			result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+		}
+		configRestart, configResult, err := r.reconcileMySQLConfig(ctx, tang)
+		if err != nil {
+			logger.Error(err, "配置生效失败")
+			configResult = ctrl.Result{RequeueAfter: podReadyRequeueInterval}
+		}
+		result = mergeResult(result, configResult)
+		rolloutResult, err := r.reconcileRollout(ctx, masterPodName, configRestart, tang)
+		if err != nil {
+			logger.Error(err, "滚动重启失败")
+			rolloutResult = ctrl.Result{RequeueAfter: podReadyRequeueInterval}
+		}
+		result = mergeResult(result, rolloutResult)
+		if !equality.Semantic.DeepEqual(statusBefore, &tang.Status) {
+			if err := r.updateStatus(ctx, tang); err != nil {
+				return ctrl.Result{}, err
+			}
 		}
 	}
 
-	return ctrl.Result{}, nil
+	return result, nil
 }
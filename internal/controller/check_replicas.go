@@ -2,9 +2,6 @@ package controller
 
 import (
 	"context"
-	"fmt"
-	"regexp"
-	"strconv"
 
 	corev1 "k8s.io/api/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -14,13 +11,14 @@ import (
 )
 
 // 调谐副本数量
-// 目前只支持扩容
+// 副本数少于预期时创建缺失的 pod，多于预期时缩掉编号最大的非主库 pod
 func (r *YellowTangReconciler) checkReplicas(ctx context.Context, tang *appsv1.YellowTang) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 	logger.Info("开始检测副本数量是否满足预期")
 
-	selectLabels := map[string]string{"tang": "true", "app": "mysql"}
-	actualPods, err := r.getPodByLabels(selectLabels, ctx, tang)
+	// 这里包含尚未就绪的 pod，避免对正在启动的 pod 重复创建
+	selectLabels := clusterLabels(tang)
+	actualPods, err := r.getAllPodByLabels(selectLabels, ctx, tang)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
@@ -29,37 +27,72 @@ func (r *YellowTangReconciler) checkReplicas(ctx context.Context, tang *appsv1.Y
 	logger.Info("当前副本情况", "副本数", actualReplicas, "预期副本数", targetReplicas)
 
 	if targetReplicas == int32(actualReplicas) {
-		// 副本数一致,无需调节
+		// 副本数一致,检查是否都已就绪，未就绪则稍后重新排队
+		if !markPodsReadiness(actualPods, tang) {
+			return ctrl.Result{RequeueAfter: podReadyRequeueInterval}, nil
+		}
 		return ctrl.Result{}, nil
 	}
 	logger.Info("副本数与预期不符", "实际副本数", actualReplicas, "预期副本数", targetReplicas)
 
-	// 创建缺失的副本
-	targetReplicasNos := generateNumberRange(1, int(targetReplicas))
-	actualReplicasNos := parsePodNos(actualPods)
+	if int32(actualReplicas) > targetReplicas {
+		if err := r.scaleIn(ctx, actualPods, tang); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: podReadyRequeueInterval}, nil
+	}
+
+	// 新 pod 的 DNS 依赖无头 service
+	if _, err := r.getorCreateHeadlessService(ctx, tang); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// 创建缺失的副本，序号从 0 开始
+	targetReplicasNos := generateNumberRange(0, int(targetReplicas)-1)
+	actualReplicasNos := parsePodNos(actualPods, tang)
 	missingReplicasNos := getMissingReplicasNos(targetReplicasNos, actualReplicasNos)
+	// 缩容后编号可能不连续（主库编号较大时会保留），只补齐缺少的数量
+	if count := int(targetReplicas) - actualReplicas; len(missingReplicasNos) > count {
+		missingReplicasNos = missingReplicasNos[:count]
+	}
 	for _, podNo := range missingReplicasNos {
 
-		podName := fmt.Sprintf("mysql-%02d", podNo)
-		pvcName := fmt.Sprintf("mysql-%02d", podNo)
-		configMapName := fmt.Sprintf("mysql-%02d", podNo)
+		podName := mysqlPodName(tang, podNo)
+		pvcName := podName
+		configMapName := podName
+
+		// 新从库从 donor 克隆数据，没有可用的 donor 时先不创建，稍后重试
+		source, err := r.getCloneSource(podName, ctx, tang)
+		if err != nil {
+			logger.Info("暂时无法克隆，稍后再创建 pod", "PodName", podName, "原因", err)
+			continue
+		}
 
 		// 如果 cm pvc pv 不存在则会新建
 		// 如果存在则不创建
-		if _, err := r.getorCreatConfigMap(configMapName, podNo, ctx, tang); err != nil {
+		if _, err := r.getorCreatConfigMap(configMapName, serverID(podNo), ctx, tang); err != nil {
 			return ctrl.Result{}, err
 		}
 		if _, err := r.getorCreatePVC(pvcName, ctx, tang); err != nil {
 			return ctrl.Result{}, err
 		}
 
-		// 创建 pod
-		if _, err := r.createPod(podName, pvcName, configMapName, ctx, tang); err != nil {
+		// 创建 pod，不等待就绪
+		pod, err := r.createPod(podName, pvcName, configMapName, source, ctx, tang)
+		if err != nil {
 			return ctrl.Result{}, err
 		}
+		if source != nil {
+			recordCloneStarted(tang, podName, source)
+			r.Recorder.Eventf(tang, corev1.EventTypeNormal, "CloneStarted", "cloning %s from %s", podName, source.Donor.Name)
+		}
+		actualPods = append(actualPods, *pod)
 		logger.Info("创建缺失的 Pod", "PodName", podName)
 	}
-	return ctrl.Result{}, nil
+
+	// 新建的 pod 就绪后由 pod 的 watch 事件或者重新排队触发主从配置
+	markPodsReadiness(actualPods, tang)
+	return ctrl.Result{RequeueAfter: podReadyRequeueInterval}, nil
 }
 
 func generateNumberRange(start, end int) []int {
@@ -74,13 +107,10 @@ func generateNumberRange(start, end int) []int {
 	return result
 }
 
-func parsePodNos(podList []corev1.Pod) []int {
+func parsePodNos(podList []corev1.Pod, tang *appsv1.YellowTang) []int {
 	var podNos []int
-	var podNamePattern = regexp.MustCompile(`mysql-(\d+)`)
-
 	for _, pod := range podList {
-		if matches := podNamePattern.FindStringSubmatch(pod.Name); len(matches) > 1 {
-			podNo, _ := strconv.Atoi(matches[1])
+		if podNo, ok := parsePodNo(pod.Name, tang); ok {
 			podNos = append(podNos, podNo)
 		}
 	}
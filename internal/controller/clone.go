@@ -0,0 +1,549 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/gtid"
+	"yellowtang/internal/mysql"
+)
+
+const (
+	cloneInitContainerName  = "clone"
+	xtrabackupContainerName = "xtrabackup"
+	// xtrabackup sidecar 提供备份流的端口
+	xtrabackupPort = 3307
+	// 未设置 spec.clone.image 时 Xtrabackup 方式使用的镜像
+	defaultXtrabackupImage = "gcr.io/google-samples/xtrabackup:1.0"
+	// clone init 容器失败重启这么多次后放弃，删除 pod 换一个 donor 重新克隆
+	cloneMaxRestarts = 3
+
+	// 账号密码 Secret 以文件挂载，sidecar 和 init 容器用到时才读取，密码轮换后不需要重启
+	credentialsVolumeName = "mysql-credentials"
+	credentialsMountPath  = "/etc/yellowtang/credentials"
+)
+
+// clone init 容器脚本的公共部分
+// 1. 上次克隆中途失败时清空不完整的数据
+// 2. 重新克隆时清空旧数据，按 pod uid 记录，同一个 pod 重启时不会再次清空
+// 3. 数据目录已经有数据时不再克隆
+const cloneScriptPrelude = `set -e
+cd /var/lib/mysql
+wipe() { find /var/lib/mysql -mindepth 1 -maxdepth 1 ! -name lost+found -exec rm -rf {} +; }
+if [ -f .yellowtang-clone-in-progress ]; then wipe; fi
+if [ "$CLONE_RESEED" = "true" ] && [ "$(cat .yellowtang-cloned 2>/dev/null)" != "$POD_UID" ]; then wipe; fi
+if [ -d mysql ]; then exit 0; fi
+touch .yellowtang-clone-in-progress
+`
+
+// 从 donor 的 xtrabackup sidecar 接收备份并 prepare
+// xtrabackup 不拷贝 binlog，备份对应的 GTID 写到终止消息中，由控制器设置 gtid_purged
+var xtrabackupCloneScript = cloneScriptPrelude + fmt.Sprintf(`ncat --recv-only "$DONOR_HOST" %d | xbstream -x -C /var/lib/mysql
+xtrabackup --prepare --target-dir=/var/lib/mysql
+cut -f3 xtrabackup_binlog_info | tr -d ' \n' > /dev/termination-log
+echo "$POD_UID" > .yellowtang-cloned
+rm -f .yellowtang-clone-in-progress
+`, xtrabackupPort)
+
+// 启动一个临时的 mysqld，通过 CLONE INSTANCE 把 donor 的数据拷贝到数据目录
+// CLONE 会保留 donor 的 gtid_executed，不需要再设置 gtid_purged
+// donor 的 root 密码按 SQL 字符串转义后写入只有自己可读的 sql 文件，从标准输入执行，不出现在命令行参数里
+var mysqlCloneScript = cloneScriptPrelude + fmt.Sprintf(`chown mysql:mysql /var/lib/mysql
+rm -rf /tmp/seed
+mysqld --no-defaults --initialize-insecure --user=mysql --datadir=/tmp/seed
+mysqld --no-defaults --user=mysql --datadir=/tmp/seed --socket=/tmp/seed.sock --skip-networking --plugin-load-add=mysql_clone.so &
+for i in $(seq 1 60); do
+  mysqladmin --socket=/tmp/seed.sock -uroot ping >/dev/null 2>&1 && break
+  sleep 1
+done
+(
+  umask 077
+  password=$(sed -e 's/\\/\\\\/g' -e "s/'/\\\\'/g" %[2]s/%[3]s)
+  cat > /tmp/clone.sql <<SQL
+SET GLOBAL clone_valid_donor_list = '$DONOR_HOST:%[1]d';
+CLONE INSTANCE FROM 'root'@'$DONOR_HOST':%[1]d IDENTIFIED BY '$password' DATA DIRECTORY = '/var/lib/mysql/clone';
+SQL
+)
+mysql --socket=/tmp/seed.sock -uroot < /tmp/clone.sql
+rm -f /tmp/clone.sql
+mysqladmin --socket=/tmp/seed.sock -uroot shutdown
+find clone -mindepth 1 -maxdepth 1 -exec mv {} . \;
+rmdir clone
+echo "$POD_UID" > .yellowtang-cloned
+rm -f .yellowtang-clone-in-progress
+`, mysql.DefaultPort, credentialsMountPath, SecretKeyRootPassword)
+
+// xtrabackup sidecar，每次有连接时对本地 MySQL 做一次流式备份
+// 每次备份前用挂载的 root 密码生成只有自己可读的 --defaults-extra-file，密码不出现在命令行参数里，轮换后也不需要重启
+var xtrabackupServeScript = fmt.Sprintf(`cat > /tmp/backup.sh <<'SCRIPT'
+umask 077
+printf '[client]\nuser=root\npassword="%%s"\n' "$(sed -e 's/\\/\\\\/g' -e 's/"/\\"/g' %[2]s/%[3]s)" > /tmp/xtrabackup.cnf
+exec xtrabackup --defaults-extra-file=/tmp/xtrabackup.cnf --backup --slave-info --stream=xbstream --host=127.0.0.1
+SCRIPT
+exec ncat --listen --keep-open --send-only --max-conns=1 %[1]d -c "sh /tmp/backup.sh"`,
+	xtrabackupPort, credentialsMountPath, SecretKeyRootPassword)
+
+// 新 pod 的克隆来源
+type cloneSource struct {
+	// 提供数据的 pod
+	Donor *corev1.Pod
+	// 清空数据目录后重新克隆
+	Reseed bool
+}
+
+// 新从库的克隆方式，未设置时不克隆
+// 升级 operator 后已有集群的 pod 模板不变，不会因为 xtrabackup sidecar 触发滚动重启
+func cloneMethod(tang *appsv1.YellowTang) appsv1.CloneMethod {
+	if tang.Spec.Clone.Method == "" {
+		return appsv1.CloneMethodNone
+	}
+	return tang.Spec.Clone.Method
+}
+
+// Xtrabackup 方式使用的镜像
+func xtrabackupImage(tang *appsv1.YellowTang) string {
+	if tang.Spec.Clone.Image != "" {
+		return tang.Spec.Clone.Image
+	}
+	return defaultXtrabackupImage
+}
+
+// 查找 status 中记录的克隆进度
+func findCloneStatus(tang *appsv1.YellowTang, name string) *appsv1.CloneStatus {
+	for i := range tang.Status.Clones {
+		if tang.Status.Clones[i].Pod == name {
+			return &tang.Status.Clones[i]
+		}
+	}
+	return nil
+}
+
+// 从 status 中去掉 pod 的克隆进度
+func removeCloneStatus(tang *appsv1.YellowTang, name string) {
+	clones := []appsv1.CloneStatus{}
+	for _, clone := range tang.Status.Clones {
+		if clone.Pod != name {
+			clones = append(clones, clone)
+		}
+	}
+	tang.Status.Clones = clones
+}
+
+// pod 是否还在克隆，克隆完成之前不作为从库修复，也不能作为 donor
+func cloneInProgress(tang *appsv1.YellowTang, name string) bool {
+	clone := findCloneStatus(tang, name)
+	return clone != nil && (clone.Phase == appsv1.ClonePhaseCloning || clone.Phase == appsv1.ClonePhaseRestoring)
+}
+
+// 在 status 中记录一次新的克隆，同一个 pod 之前的记录被覆盖
+func recordCloneStarted(tang *appsv1.YellowTang, podName string, source *cloneSource) {
+	clone := appsv1.CloneStatus{
+		Pod:       podName,
+		Donor:     source.Donor.Name,
+		Method:    cloneMethod(tang),
+		Phase:     appsv1.ClonePhaseCloning,
+		Message:   fmt.Sprintf("waiting to copy data from %s", source.Donor.Name),
+		StartTime: metav1.Now(),
+	}
+	if existing := findCloneStatus(tang, podName); existing != nil {
+		*existing = clone
+		return
+	}
+	tang.Status.Clones = append(tang.Status.Clones, clone)
+}
+
+// pod 是否有指定名字的容器
+func hasContainer(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取新 pod 的克隆来源
+// 不需要克隆时返回 nil；需要克隆但是没有可用的 donor 时返回错误，调用方稍后重试
+func (r *YellowTangReconciler) getCloneSource(podName string, ctx context.Context, tang *appsv1.YellowTang) (*cloneSource, error) {
+	if cloneMethod(tang) == appsv1.CloneMethodNone || tang.Status.MasterPod == "" {
+		return nil, nil
+	}
+	// 组复制的新成员通过分布式恢复从组中拷贝数据
+	if topology(tang) == appsv1.TopologyGroupReplication {
+		return nil, nil
+	}
+
+	// 被隔离的从库重新克隆时要先清空旧数据
+	fenced := findFencedPod(tang, podName)
+	reseed := tang.Spec.Clone.RecloneQuarantined && fenced != nil && fenced.NeedsReclone
+
+	// PVC 已经有数据时 init 容器也会跳过克隆，重启或重建 pod 时不需要 donor
+	_, err := r.getPVC(client.ObjectKey{Namespace: tang.Namespace, Name: podName}, ctx, tang)
+	if err == nil && !reseed {
+		return nil, nil
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	donor, err := r.selectCloneDonor(ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+	if donor == nil {
+		// 开启克隆之前创建的集群没有 sidecar，只能按原来的方式从空数据目录开始
+		if cloneMethod(tang) == appsv1.CloneMethodXtrabackup {
+			hasSidecar, err := r.clusterHasXtrabackupSidecar(ctx, tang)
+			if err != nil {
+				return nil, err
+			}
+			if !hasSidecar {
+				log.FromContext(ctx).Info("集群中没有带 xtrabackup sidecar 的 pod，不克隆", "Pod", podName)
+				return nil, nil
+			}
+		}
+		return nil, fmt.Errorf("no healthy donor to clone %s from", podName)
+	}
+
+	return &cloneSource{Donor: donor, Reseed: reseed}, nil
+}
+
+// 集群中是否有 pod 带 xtrabackup sidecar
+func (r *YellowTangReconciler) clusterHasXtrabackupSidecar(ctx context.Context, tang *appsv1.YellowTang) (bool, error) {
+	pods, err := r.getAllPodByLabels(clusterLabels(tang), ctx, tang)
+	if err != nil {
+		return false, err
+	}
+	for i := range pods {
+		if hasContainer(&pods[i], xtrabackupContainerName) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 选择 donor：优先复制正常、延迟最小的从库，避免给主库增加负载，没有可用的从库时使用主库
+func (r *YellowTangReconciler) selectCloneDonor(ctx context.Context, tang *appsv1.YellowTang) (*corev1.Pod, error) {
+	pods, err := r.getPodByLabels(clusterLabels(tang), ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaLag := map[string]int64{}
+	for _, replica := range tang.Status.Replicas {
+		if replica.IOThreadRunning && replica.SQLThreadRunning && replica.ErrantGtidSet == "" && !isDelayedReplica(replica.Name, tang) {
+			lag := int64(0)
+			if replica.SecondsBehindMaster != nil {
+				lag = *replica.SecondsBehindMaster
+			}
+			replicaLag[replica.Name] = lag
+		}
+	}
+
+	candidates := []corev1.Pod{}
+	var master *corev1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if isFencedPod(pod, tang) || pod.Labels["role"] == roleRemoving || cloneInProgress(tang, pod.Name) {
+			continue
+		}
+		// Xtrabackup 方式需要 donor 上有 sidecar，开启克隆之前创建的 pod 没有
+		if cloneMethod(tang) == appsv1.CloneMethodXtrabackup && !hasContainer(pod, xtrabackupContainerName) {
+			continue
+		}
+		if pod.Name == tang.Status.MasterPod {
+			master = pod
+			continue
+		}
+		if _, ok := replicaLag[pod.Name]; ok {
+			candidates = append(candidates, *pod)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return replicaLag[candidates[i].Name] < replicaLag[candidates[j].Name]
+	})
+	if master != nil {
+		candidates = append(candidates, *master)
+	}
+
+	for i := range candidates {
+		ready, err := r.isCloneDonorReady(ctx, &candidates[i], tang)
+		if err != nil {
+			log.FromContext(ctx).Info("donor 检查失败", "Pod", candidates[i].Name, "错误", err)
+			continue
+		}
+		if ready {
+			return &candidates[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// donor 是否可以提供数据
+// Clone 方式要求 donor 已经加载 CLONE 插件；从库开启了 super_read_only 不能安装插件，只给主库安装
+func (r *YellowTangReconciler) isCloneDonorReady(ctx context.Context, donor *corev1.Pod, tang *appsv1.YellowTang) (bool, error) {
+	if cloneMethod(tang) != appsv1.CloneMethodClone {
+		return true, nil
+	}
+
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return false, err
+	}
+	target := rootTarget(donor, creds.RootPassword)
+	active, err := mysql.IsPluginActive(ctx, r.SQL, target, "clone")
+	if err != nil || active {
+		return active, err
+	}
+	if donor.Name != tang.Status.MasterPod {
+		return false, nil
+	}
+	if err := r.SQL.Exec(ctx, target, "INSTALL PLUGIN clone SONAME 'mysql_clone.so'"); err != nil {
+		return false, fmt.Errorf("failed to install clone plugin on %s: %v", donor.Name, err)
+	}
+	return true, nil
+}
+
+// 给 pod 加上 clone init 容器；Xtrabackup 方式下所有 pod 都带 sidecar，之后可以作为 donor
+func addCloneContainers(pod *corev1.Pod, source *cloneSource, secretName string, tang *appsv1.YellowTang) {
+	method := cloneMethod(tang)
+	if method == appsv1.CloneMethodNone || (method != appsv1.CloneMethodXtrabackup && source == nil) {
+		return
+	}
+
+	dataMount := corev1.VolumeMount{Name: "mysql-data", MountPath: "/var/lib/mysql"}
+	credentialsMount := corev1.VolumeMount{Name: credentialsVolumeName, MountPath: credentialsMountPath, ReadOnly: true}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: credentialsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	})
+
+	if method == appsv1.CloneMethodXtrabackup {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:    xtrabackupContainerName,
+			Image:   xtrabackupImage(tang),
+			Command: []string{"/bin/sh", "-c", xtrabackupServeScript},
+			Ports: []corev1.ContainerPort{
+				{
+					Name:          "xtrabackup",
+					ContainerPort: xtrabackupPort,
+				},
+			},
+			VolumeMounts: []corev1.VolumeMount{dataMount, credentialsMount},
+		})
+	}
+
+	if source == nil {
+		return
+	}
+	image, script := tang.Spec.Image, mysqlCloneScript
+	if method == appsv1.CloneMethodXtrabackup {
+		image, script = xtrabackupImage(tang), xtrabackupCloneScript
+	}
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+		Name:    cloneInitContainerName,
+		Image:   image,
+		Command: []string{"/bin/sh", "-c", script},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "DONOR_HOST",
+				Value: source.Donor.Status.PodIP,
+			},
+			{
+				Name:  "CLONE_RESEED",
+				Value: fmt.Sprintf("%t", source.Reseed),
+			},
+			{
+				Name: "POD_UID",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.uid"},
+				},
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{dataMount, credentialsMount},
+		// 失败时终止消息取日志的最后几行，写到 status.clones 中
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	})
+}
+
+// 跟踪正在进行的克隆，并按 spec.clone.recloneQuarantined 重新克隆被隔离的从库
+func (r *YellowTangReconciler) reconcileClones(ctx context.Context, tang *appsv1.YellowTang) error {
+	failed := []string{}
+	for i := range tang.Status.Clones {
+		clone := &tang.Status.Clones[i]
+		if clone.Phase != appsv1.ClonePhaseCloning && clone.Phase != appsv1.ClonePhaseRestoring {
+			continue
+		}
+		if err := r.trackClone(ctx, clone, tang); err != nil {
+			log.FromContext(ctx).Info("克隆进度检查失败", "Pod", clone.Pod, "错误", err)
+			failed = append(failed, clone.Pod)
+		}
+	}
+
+	if err := r.recloneQuarantinedPods(ctx, tang); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to track clone of %s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// clone init 容器的状态
+func cloneContainerStatus(pod *corev1.Pod) *corev1.ContainerStatus {
+	for i := range pod.Status.InitContainerStatuses {
+		if pod.Status.InitContainerStatuses[i].Name == cloneInitContainerName {
+			return &pod.Status.InitContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// 根据 clone init 容器和 pod 的状态推进克隆的步骤
+// Cloning: init 容器成功退出后进入 Restoring，重启次数达到上限时放弃并删除 pod
+// Restoring: MySQL 就绪后恢复 GTID，之后由从库检查配置复制
+func (r *YellowTangReconciler) trackClone(ctx context.Context, clone *appsv1.CloneStatus, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	pod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: clone.Pod}, ctx, tang)
+	if errors.IsNotFound(err) {
+		// 由副本数检查重新创建，重新创建时会重新记录
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if pod.DeletionTimestamp != nil {
+		return nil
+	}
+
+	if clone.Phase == appsv1.ClonePhaseCloning {
+		cs := cloneContainerStatus(pod)
+		if cs == nil {
+			return nil
+		}
+		clone.Restarts = cs.RestartCount
+		if terminated := cs.State.Terminated; terminated != nil && terminated.ExitCode == 0 {
+			clone.Phase = appsv1.ClonePhaseRestoring
+			clone.GTIDSet = strings.TrimSpace(terminated.Message)
+			clone.Message = "data copied, waiting for mysqld to become ready"
+		} else if cs.RestartCount >= cloneMaxRestarts {
+			reason := fmt.Sprintf("clone init container failed %d times", cs.RestartCount)
+			if last := cs.LastTerminationState.Terminated; last != nil && last.Message != "" {
+				reason = fmt.Sprintf("%s: %s", reason, strings.TrimSpace(last.Message))
+			}
+			now := metav1.Now()
+			clone.Phase = appsv1.ClonePhaseFailed
+			clone.Message = reason
+			clone.CompletionTime = &now
+			logger.Info("克隆失败，删除 pod 后换一个 donor 重新克隆", "Pod", pod.Name, "donor", clone.Donor)
+			r.Recorder.Eventf(tang, corev1.EventTypeWarning, "CloneFailed", "cloning %s from %s failed: %s", pod.Name, clone.Donor, reason)
+			if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete pod %s: %v", pod.Name, err)
+			}
+			return nil
+		} else {
+			clone.Message = fmt.Sprintf("copying data from %s", clone.Donor)
+			return nil
+		}
+	}
+
+	if !isPodHealthy(*pod) {
+		return nil
+	}
+	if err := r.restoreClonedGTIDSet(ctx, pod, clone, tang); err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	clone.Phase = appsv1.ClonePhaseCompleted
+	clone.Message = fmt.Sprintf("cloned from %s", clone.Donor)
+	clone.CompletionTime = &now
+	// 重新克隆的被隔离从库已经没有 errant 事务，可以作为从库加入
+	if fenced := findFencedPod(tang, pod.Name); fenced != nil && fenced.NeedsReclone {
+		removeFencedPod(tang, pod.Name)
+	}
+	logger.Info("克隆完成", "Pod", pod.Name, "donor", clone.Donor, "gtid", clone.GTIDSet)
+	r.Recorder.Eventf(tang, corev1.EventTypeNormal, "CloneCompleted", "%s cloned from %s at %s", pod.Name, clone.Donor, clone.GTIDSet)
+	return nil
+}
+
+// 让克隆出来的实例从备份的 GTID 开始复制
+// xtrabackup 不拷贝 binlog，恢复出来的实例不知道自己执行过哪些事务，需要设置 gtid_purged
+func (r *YellowTangReconciler) restoreClonedGTIDSet(ctx context.Context, pod *corev1.Pod, clone *appsv1.CloneStatus, tang *appsv1.YellowTang) error {
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return err
+	}
+	executed, err := mysql.GetExecutedGTIDSet(ctx, r.SQL, rootTarget(pod, creds.RootPassword))
+	if err != nil {
+		return fmt.Errorf("failed to read gtid_executed on %s: %v", pod.Name, err)
+	}
+
+	if clone.GTIDSet != "" {
+		cloned, err := gtid.Parse(clone.GTIDSet)
+		if err != nil {
+			return fmt.Errorf("failed to parse cloned gtid set of %s: %v", pod.Name, err)
+		}
+		executedSet, err := gtid.Parse(executed)
+		if err != nil {
+			return err
+		}
+		if !cloned.IsSubsetOf(executedSet) {
+			if err := r.execSQL(ctx, pod, creds,
+				"STOP SLAVE",
+				"RESET SLAVE ALL",
+				"RESET MASTER",
+				fmt.Sprintf("SET GLOBAL gtid_purged = %s", mysql.QuoteString(cloned.String())),
+			); err != nil {
+				return fmt.Errorf("failed to set gtid_purged on %s: %v", pod.Name, err)
+			}
+			executed = cloned.String()
+		}
+	}
+	clone.GTIDSet = executed
+	return nil
+}
+
+// 删除因为 errant 事务被隔离的从库，副本数检查会清空它的数据并重新克隆
+func (r *YellowTangReconciler) recloneQuarantinedPods(ctx context.Context, tang *appsv1.YellowTang) error {
+	if !tang.Spec.Clone.RecloneQuarantined || cloneMethod(tang) == appsv1.CloneMethodNone {
+		return nil
+	}
+
+	for _, name := range quarantinedPods(tang) {
+		if cloneInProgress(tang, name) {
+			continue
+		}
+		pod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: name}, ctx, tang)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		log.FromContext(ctx).Info("删除被隔离的从库，重新克隆", "Pod", name)
+		r.Recorder.Eventf(tang, corev1.EventTypeNormal, "RecloningReplica", "%s is deleted to be re-cloned", name)
+		if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pod %s: %v", name, err)
+		}
+	}
+	return nil
+}
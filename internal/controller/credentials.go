@@ -0,0 +1,263 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+const (
+	// Secret 中保存账号密码的 key
+	SecretKeyRootPassword        = "root-password"
+	SecretKeyReplicationUser     = "replication-user"
+	SecretKeyReplicationPassword = "replication-password"
+	// 只在 operator 维护的快照 Secret 中使用
+	secretKeyPreviousRootPassword = "previous-root-password"
+
+	// 默认的复制账号
+	defaultReplicationUser = "replica"
+	// 生成的随机密码长度
+	generatedPasswordLength = 24
+	// 旧版本写死在控制器里的密码，只用于给已经初始化过的集群补建 Secret
+	legacyPassword = "password"
+)
+
+// 只使用字母和数字，避免在 shell 和 sql 中转义
+const passwordAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// MySQL 账号密码
+type mysqlCredentials struct {
+	RootPassword        string
+	ReplicationUser     string
+	ReplicationPassword string
+}
+
+// 保存账号密码的 Secret 名字
+func credentialsSecretName(tang *appsv1.YellowTang) string {
+	if tang.Spec.Credentials.SecretName != "" {
+		return tang.Spec.Credentials.SecretName
+	}
+	return fmt.Sprintf("%s-credentials", tang.Name)
+}
+
+// 生成随机密码
+func generatePassword(length int) (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(passwordAlphabet)))
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(passwordAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+func (r *YellowTangReconciler) getSecret(secretKey client.ObjectKey, ctx context.Context, tang *appsv1.YellowTang) (*corev1.Secret, error) {
+	secret := corev1.Secret{}
+	if err := r.Get(ctx, secretKey, &secret); err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+func (r *YellowTangReconciler) getorCreateCredentialsSecret(ctx context.Context, tang *appsv1.YellowTang) (*corev1.Secret, error) {
+	secretKey := client.ObjectKey{Namespace: tang.Namespace, Name: credentialsSecretName(tang)}
+	secret, err := r.getSecret(secretKey, ctx, tang)
+	if err == nil {
+		return secret, nil
+	}
+
+	if errors.IsNotFound(err) {
+		secret, err := r.createCredentialsSecret(secretKey.Name, ctx, tang)
+		if err == nil {
+			return secret, nil
+		}
+		return nil, err
+	}
+	return nil, err
+}
+
+// 创建账号密码 Secret
+// 新集群使用随机密码；已经初始化过的旧集群沿用原来写死的密码，避免和库里实际的密码不一致
+func (r *YellowTangReconciler) createCredentialsSecret(name string, ctx context.Context, tang *appsv1.YellowTang) (*corev1.Secret, error) {
+	logger := log.FromContext(ctx)
+
+	// 定义 OwnerReference
+	ownerRef := metav1.OwnerReference{
+		APIVersion: MysqlClusterAPIVersion,
+		Kind:       MysqlClusterKind,
+		Name:       tang.Name, // yellowtang-sample
+		UID:        tang.UID,
+		Controller: func(b bool) *bool { return &b }(true),
+	}
+
+	rootPassword, replicationPassword := legacyPassword, legacyPassword
+	if tang.Status.InitStep != appsv1.InitStepDone {
+		var err error
+		if rootPassword, err = generatePassword(generatedPasswordLength); err != nil {
+			return nil, err
+		}
+		if replicationPassword, err = generatePassword(generatedPasswordLength); err != nil {
+			return nil, err
+		}
+	} else {
+		logger.Info("集群已经初始化但是没有账号密码 Secret，使用旧版本的默认密码创建", "secret", name)
+	}
+
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: tang.Namespace,
+			Labels:    clusterLabels(tang),
+			OwnerReferences: []metav1.OwnerReference{
+				ownerRef,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		StringData: map[string]string{
+			SecretKeyRootPassword:        rootPassword,
+			SecretKeyReplicationUser:     defaultReplicationUser,
+			SecretKeyReplicationPassword: replicationPassword,
+		},
+	}
+	if err := r.Create(ctx, &secret); err != nil {
+		return nil, err
+	}
+	logger.Info("Secret created successfully", "Secret.Name", name)
+	return &secret, nil
+}
+
+// 从 Secret 中读取账号密码
+func credentialsFromSecret(secret *corev1.Secret) (*mysqlCredentials, error) {
+	value := func(key string) string {
+		if v, ok := secret.Data[key]; ok {
+			return string(v)
+		}
+		// 刚创建的 Secret 只有 StringData
+		return secret.StringData[key]
+	}
+
+	creds := &mysqlCredentials{
+		RootPassword:        value(SecretKeyRootPassword),
+		ReplicationUser:     value(SecretKeyReplicationUser),
+		ReplicationPassword: value(SecretKeyReplicationPassword),
+	}
+	if creds.ReplicationUser == "" {
+		creds.ReplicationUser = defaultReplicationUser
+	}
+	if creds.RootPassword == "" {
+		return nil, fmt.Errorf("secret %s has no %s", secret.Name, SecretKeyRootPassword)
+	}
+	if creds.ReplicationPassword == "" {
+		return nil, fmt.Errorf("secret %s has no %s", secret.Name, SecretKeyReplicationPassword)
+	}
+	return creds, nil
+}
+
+// 获取 Secret 中期望的账号密码，Secret 不存在时会先创建
+func (r *YellowTangReconciler) getDesiredCredentials(ctx context.Context, tang *appsv1.YellowTang) (*mysqlCredentials, error) {
+	secret, err := r.getorCreateCredentialsSecret(ctx, tang)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials secret: %v", err)
+	}
+	return credentialsFromSecret(secret)
+}
+
+// 获取 MySQL 中实际生效的账号密码，所有连接 MySQL 的地方都使用它
+// 用户修改 Secret 后，在轮换完成之前库里仍然是旧密码，所以这里读取的是 operator 维护的快照
+func (r *YellowTangReconciler) getCredentials(ctx context.Context, tang *appsv1.YellowTang) (*mysqlCredentials, error) {
+	secret, err := r.getorCreateAppliedCredentialsSecret(ctx, tang)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied credentials secret: %v", err)
+	}
+	return credentialsFromSecret(secret)
+}
+
+// 上一次轮换之前的 root 密码，轮换过程中从库可能还没有应用新密码
+func (r *YellowTangReconciler) getPreviousRootPassword(ctx context.Context, tang *appsv1.YellowTang) (string, error) {
+	secret, err := r.getorCreateAppliedCredentialsSecret(ctx, tang)
+	if err != nil {
+		return "", err
+	}
+	return string(secret.Data[secretKeyPreviousRootPassword]), nil
+}
+
+// 保存实际生效账号密码的 Secret 名字
+func appliedCredentialsSecretName(tang *appsv1.YellowTang) string {
+	return fmt.Sprintf("%s-credentials-applied", tang.Name)
+}
+
+func (r *YellowTangReconciler) getorCreateAppliedCredentialsSecret(ctx context.Context, tang *appsv1.YellowTang) (*corev1.Secret, error) {
+	secretKey := client.ObjectKey{Namespace: tang.Namespace, Name: appliedCredentialsSecretName(tang)}
+	secret, err := r.getSecret(secretKey, ctx, tang)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	// 第一次使用时，库里的密码就是 Secret 中的密码
+	desired, err := r.getDesiredCredentials(ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+
+	// 定义 OwnerReference
+	ownerRef := metav1.OwnerReference{
+		APIVersion: MysqlClusterAPIVersion,
+		Kind:       MysqlClusterKind,
+		Name:       tang.Name, // yellowtang-sample
+		UID:        tang.UID,
+		Controller: func(b bool) *bool { return &b }(true),
+	}
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretKey.Name,
+			Namespace: tang.Namespace,
+			Labels:    clusterLabels(tang),
+			OwnerReferences: []metav1.OwnerReference{
+				ownerRef,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: credentialsData(desired),
+	}
+	if err := r.Create(ctx, secret); err != nil {
+		return nil, err
+	}
+	log.FromContext(ctx).Info("Secret created successfully", "Secret.Name", secretKey.Name)
+	return secret, nil
+}
+
+// 更新实际生效的账号密码，同时保留上一次的 root 密码
+func (r *YellowTangReconciler) saveAppliedCredentials(creds *mysqlCredentials, ctx context.Context, tang *appsv1.YellowTang) error {
+	secret, err := r.getorCreateAppliedCredentialsSecret(ctx, tang)
+	if err != nil {
+		return err
+	}
+	previous := secret.Data[SecretKeyRootPassword]
+	secret.Data = credentialsData(creds)
+	secret.Data[secretKeyPreviousRootPassword] = previous
+	return r.Update(ctx, secret)
+}
+
+func credentialsData(creds *mysqlCredentials) map[string][]byte {
+	return map[string][]byte{
+		SecretKeyRootPassword:        []byte(creds.RootPassword),
+		SecretKeyReplicationUser:     []byte(creds.ReplicationUser),
+		SecretKeyReplicationPassword: []byte(creds.ReplicationPassword),
+	}
+}
@@ -0,0 +1,262 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/gtid"
+	"yellowtang/internal/mysql"
+)
+
+// 延迟从库的 role 标签，不会被 slave-service 选中，选主时也不会被选中
+const roleDelayed = "delayed"
+
+// 延迟从库的总数
+func delayedReplicaCount(tang *appsv1.YellowTang) int {
+	count := 0
+	for _, delayed := range tang.Spec.DelayedReplicas {
+		count += int(delayed.Count)
+	}
+	return count
+}
+
+// pod 的 MASTER_DELAY，不是延迟从库时为 0
+// 延迟从库占用编号最大的 pod，按 spec.delayedReplicas 的顺序分配延迟时间，编号 0 的 pod 不会延迟
+func replicaDelay(podName string, tang *appsv1.YellowTang) int32 {
+	if topology(tang) == appsv1.TopologyGroupReplication {
+		return 0
+	}
+	ordinal, ok := parsePodNo(podName, tang)
+	if !ok {
+		return 0
+	}
+	next := int(tang.Spec.Replicas) - delayedReplicaCount(tang)
+	if next < 1 {
+		next = 1
+	}
+	for _, delayed := range tang.Spec.DelayedReplicas {
+		for i := 0; i < int(delayed.Count); i++ {
+			if ordinal == next {
+				return delayed.DelaySeconds
+			}
+			next++
+		}
+	}
+	return 0
+}
+
+// 是否延迟从库
+func isDelayedReplica(podName string, tang *appsv1.YellowTang) bool {
+	return replicaDelay(podName, tang) > 0
+}
+
+// 从库的 role 标签：延迟从库为 delayed，其他为 slave
+func slaveRole(podName string, tang *appsv1.YellowTang) string {
+	if isDelayedReplica(podName, tang) {
+		return roleDelayed
+	}
+	return "slave"
+}
+
+// role 标签是否表示从库
+func isSlaveRole(role string) bool {
+	return role == "slave" || role == roleDelayed
+}
+
+// 延迟从库是否按 spec.delayedReplicaStops 停止了 SQL 线程或者正在等待停止
+// 这时 SQL 线程没有运行不算复制中断
+func delayedReplicaStopActive(podName string, tang *appsv1.YellowTang) bool {
+	for _, stop := range tang.Status.DelayedReplicaStops {
+		if stop.Pod == podName && (stop.Phase == appsv1.DelayedReplicaStopStopping || stop.Phase == appsv1.DelayedReplicaStopStopped) {
+			return true
+		}
+	}
+	return false
+}
+
+// 让从库的 MASTER_DELAY 和 spec.delayedReplicas 一致，只需要重启 SQL 线程
+// 正在按 spec.delayedReplicaStops 停止的延迟从库不修改，重启 SQL 线程会清掉 UNTIL 条件
+func (r *YellowTangReconciler) reconcileReplicaDelays(ctx context.Context, slavePods []corev1.Pod, tang *appsv1.YellowTang) error {
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return err
+	}
+
+	configured := map[string]int32{}
+	for _, replica := range tang.Status.Replicas {
+		if replica.IOThreadRunning {
+			configured[replica.Name] = replica.DelaySeconds
+		}
+	}
+	failed := []string{}
+	for i := range slavePods {
+		pod := &slavePods[i]
+		current, ok := configured[pod.Name]
+		desired := replicaDelay(pod.Name, tang)
+		if !ok || current == desired || !isPodHealthy(*pod) || delayedReplicaStopActive(pod.Name, tang) {
+			continue
+		}
+
+		log.FromContext(ctx).Info("修改从库延迟", "Pod", pod.Name, "当前延迟", current, "期望延迟", desired)
+		if err := r.execSQL(ctx, pod, creds,
+			"STOP SLAVE SQL_THREAD",
+			fmt.Sprintf("CHANGE MASTER TO MASTER_DELAY=%d", desired),
+			"START SLAVE SQL_THREAD",
+		); err != nil {
+			log.FromContext(ctx).Info("修改从库延迟失败", "Pod", pod.Name, "错误", err)
+			failed = append(failed, pod.Name)
+			continue
+		}
+		r.Recorder.Eventf(tang, corev1.EventTypeNormal, "ReplicaDelayChanged", "changed MASTER_DELAY of %s from %d to %d seconds", pod.Name, current, desired)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to change replica delay on %s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// 按 spec.delayedReplicaStops 在指定事务之前停止延迟从库的 SQL 线程，进度写入 status.delayedReplicaStops
+// 1. 延迟从库已经执行了指定事务时标记为 TooLate，不再停止
+// 2. SQL 线程还在运行且没有 UNTIL 条件时重新设置，pod 重启后 SQL 线程会不带条件启动
+// 3. SQL 线程停止后标记为 Stopped，之后可以从延迟从库上取出数据
+// 4. 从 spec 中删除后重新启动 SQL 线程
+func (r *YellowTangReconciler) reconcileDelayedReplicaStops(ctx context.Context, masterPodName string, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	previous := map[string]appsv1.DelayedReplicaStopStatus{}
+	for _, stop := range tang.Status.DelayedReplicaStops {
+		previous[stop.Pod] = stop
+	}
+	desired := map[string]string{}
+	for _, stop := range tang.Spec.DelayedReplicaStops {
+		desired[stop.Pod] = stop.BeforeGTIDSet
+	}
+
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return err
+	}
+
+	// 删除或者修改了的停止请求先恢复 SQL 线程
+	for name, stop := range previous {
+		if desired[name] == stop.BeforeGTIDSet || !delayedReplicaStopActive(name, tang) {
+			continue
+		}
+		pod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: name}, ctx, tang)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		logger.Info("恢复延迟从库的 SQL 线程", "Pod", name)
+		if err := r.execSQL(ctx, pod, creds, "STOP SLAVE SQL_THREAD", "START SLAVE SQL_THREAD"); err != nil {
+			return fmt.Errorf("failed to resume sql thread on %s: %v", name, err)
+		}
+		r.Recorder.Eventf(tang, corev1.EventTypeNormal, "DelayedReplicaResumed", "resumed sql thread on delayed replica %s", name)
+	}
+
+	statuses := []appsv1.DelayedReplicaStopStatus{}
+	for _, stop := range tang.Spec.DelayedReplicaStops {
+		status := appsv1.DelayedReplicaStopStatus{Pod: stop.Pod, BeforeGTIDSet: stop.BeforeGTIDSet}
+		if old, ok := previous[stop.Pod]; ok && old.BeforeGTIDSet == stop.BeforeGTIDSet {
+			status = old
+		}
+		if err := r.stopDelayedReplica(ctx, masterPodName, &status, creds, tang); err != nil {
+			return err
+		}
+		statuses = append(statuses, status)
+	}
+	tang.Status.DelayedReplicaStops = nil
+	if len(statuses) > 0 {
+		tang.Status.DelayedReplicaStops = statuses
+	}
+	return nil
+}
+
+// 处理一个延迟从库的停止请求，更新 status 中的阶段
+func (r *YellowTangReconciler) stopDelayedReplica(ctx context.Context, masterPodName string, status *appsv1.DelayedReplicaStopStatus, creds *mysqlCredentials, tang *appsv1.YellowTang) error {
+	setPhase := func(phase appsv1.DelayedReplicaStopPhase, message string) {
+		if status.Phase != phase {
+			eventType := corev1.EventTypeNormal
+			if phase == appsv1.DelayedReplicaStopTooLate || phase == appsv1.DelayedReplicaStopInvalid {
+				eventType = corev1.EventTypeWarning
+			}
+			r.Recorder.Eventf(tang, eventType, "DelayedReplica"+string(phase), "%s: %s", status.Pod, message)
+		}
+		status.Phase, status.Message = phase, message
+	}
+
+	if status.Pod == masterPodName || !isDelayedReplica(status.Pod, tang) {
+		setPhase(appsv1.DelayedReplicaStopInvalid, fmt.Sprintf("%s is not a delayed replica", status.Pod))
+		return nil
+	}
+	before, err := gtid.Parse(status.BeforeGTIDSet)
+	if err != nil || before.IsEmpty() {
+		setPhase(appsv1.DelayedReplicaStopInvalid, fmt.Sprintf("invalid gtid set %q", status.BeforeGTIDSet))
+		return nil
+	}
+	if status.Phase == appsv1.DelayedReplicaStopTooLate {
+		return nil
+	}
+
+	pod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: status.Pod}, ctx, tang)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !isPodHealthy(*pod) {
+		return nil
+	}
+
+	target := rootTarget(pod, creds.RootPassword)
+	executed, err := mysql.GetExecutedGTIDSet(ctx, r.SQL, target)
+	if err != nil {
+		return fmt.Errorf("failed to read gtid_executed on %s: %v", pod.Name, err)
+	}
+	executedSet, err := gtid.Parse(executed)
+	if err != nil {
+		return fmt.Errorf("failed to parse gtid_executed of %s: %v", pod.Name, err)
+	}
+	if applied := executedSet.Intersect(before); !applied.IsEmpty() {
+		setPhase(appsv1.DelayedReplicaStopTooLate, fmt.Sprintf("%s has already applied %s", pod.Name, applied.String()))
+		return nil
+	}
+
+	replica, err := r.getReplicaStatus(ctx, pod, creds)
+	if err != nil {
+		return fmt.Errorf("failed to read replica status on %s: %v", pod.Name, err)
+	}
+	if replica == nil {
+		return nil
+	}
+	if !replica.SQLThreadRunning {
+		setPhase(appsv1.DelayedReplicaStopStopped, fmt.Sprintf("sql thread stopped before %s", status.BeforeGTIDSet))
+		return nil
+	}
+	if replica.UntilCondition != "SQL_BEFORE_GTIDS" {
+		log.FromContext(ctx).Info("延迟从库在指定事务之前停止 SQL 线程", "Pod", pod.Name, "事务", status.BeforeGTIDSet)
+		if err := r.execSQL(ctx, pod, creds,
+			"STOP SLAVE SQL_THREAD",
+			fmt.Sprintf("START SLAVE SQL_THREAD UNTIL SQL_BEFORE_GTIDS = %s", mysql.QuoteString(before.String())),
+		); err != nil {
+			return fmt.Errorf("failed to stop sql thread on %s: %v", pod.Name, err)
+		}
+		if status.StartTime == nil {
+			now := metav1.Now()
+			status.StartTime = &now
+		}
+	}
+	setPhase(appsv1.DelayedReplicaStopStopping, fmt.Sprintf("sql thread will stop before %s", status.BeforeGTIDSet))
+	return nil
+}
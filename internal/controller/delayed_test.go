@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+func TestReplicaDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int32
+		topology appsv1.Topology
+		delayed  []appsv1.DelayedReplicaSpec
+		// 按 pod 编号的 MASTER_DELAY
+		want []int32
+	}{
+		{
+			name:     "no delayed replicas",
+			replicas: 3,
+			want:     []int32{0, 0, 0},
+		},
+		{
+			name:     "highest ordinal is delayed",
+			replicas: 3,
+			delayed:  []appsv1.DelayedReplicaSpec{{Count: 1, DelaySeconds: 3600}},
+			want:     []int32{0, 0, 3600},
+		},
+		{
+			name:     "delays assigned in spec order",
+			replicas: 5,
+			delayed: []appsv1.DelayedReplicaSpec{
+				{Count: 1, DelaySeconds: 600},
+				{Count: 2, DelaySeconds: 3600},
+			},
+			want: []int32{0, 0, 600, 3600, 3600},
+		},
+		{
+			name:     "ordinal 0 is never delayed",
+			replicas: 2,
+			delayed:  []appsv1.DelayedReplicaSpec{{Count: 3, DelaySeconds: 60}},
+			want:     []int32{0, 60},
+		},
+		{
+			name:     "zero count is skipped",
+			replicas: 3,
+			delayed: []appsv1.DelayedReplicaSpec{
+				{Count: 0, DelaySeconds: 600},
+				{Count: 1, DelaySeconds: 60},
+			},
+			want: []int32{0, 0, 60},
+		},
+		{
+			name:     "group replication has no delayed replicas",
+			replicas: 3,
+			topology: appsv1.TopologyGroupReplication,
+			delayed:  []appsv1.DelayedReplicaSpec{{Count: 1, DelaySeconds: 3600}},
+			want:     []int32{0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tang := &appsv1.YellowTang{
+				ObjectMeta: metav1.ObjectMeta{Name: "demo"},
+				Spec: appsv1.YellowTangSpec{
+					Replicas:        tt.replicas,
+					Topology:        tt.topology,
+					DelayedReplicas: tt.delayed,
+				},
+			}
+			for ordinal, want := range tt.want {
+				name := mysqlPodName(tang, ordinal)
+				if got := replicaDelay(name, tang); got != want {
+					t.Errorf("replicaDelay(%s) = %d, want %d", name, got, want)
+				}
+				wantRole := "slave"
+				if want > 0 {
+					wantRole = roleDelayed
+				}
+				if got := slaveRole(name, tang); got != wantRole {
+					t.Errorf("slaveRole(%s) = %s, want %s", name, got, wantRole)
+				}
+			}
+			if got := replicaDelay("other-mysql-2", tang); got != 0 {
+				t.Errorf("replicaDelay(other-mysql-2) = %d, want 0", got)
+			}
+		})
+	}
+}
@@ -1,182 +1,168 @@
-package controller
-
-import (
-	"context"
-	"fmt"
-	"strconv"
-	"strings"
-	appsv1 "yellowtang/api/v1"
-
-	corev1 "k8s.io/api/core/v1"
-	"sigs.k8s.io/controller-runtime/pkg/log"
-)
-
-// 选主逻辑：
-/*
-MHA数据一致性和选择
-GTID模式：MHA会比较每个从库的GTID集合。选择包含主库GTID率高的的从库作为新的主库。
-*/
-func (r *YellowTangReconciler) electNewMaster(ctx context.Context, tang *appsv1.YellowTang) (string, []string, error) {
-	logger := log.FromContext(ctx)
-	logger.Info("开始选举新主...")
-
-	labels := map[string]string{"tang": "true", "app": "mysql", "role": "slave"}
-	slavePodList, err := r.getPodByLabels(labels, ctx, tang)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to list slave pods: %v", err)
-	}
-	_slavePodNameList := []string{}
-	for _, pod := range slavePodList {
-		_slavePodNameList = append(_slavePodNameList, pod.Name)
-	}
-	logger.Info("所有从pod", "Pod", _slavePodNameList)
-
-	// 初始化选举变量
-	var bestSlave *corev1.Pod
-	var highestScore float64 = -1
-
-	// 遍历所有从库 Pod
-	for _, pod := range slavePodList {
-		// 确保 Pod 健康
-		if !isPodHealthy(pod) {
-			continue
-		}
-
-		// 计算数据得分
-		dataScore, err := r.getDataScore(ctx, &pod)
-		if err != nil {
-			return "", nil, fmt.Errorf("failed to get data score for pod %s: %v", pod.Name, err)
-		}
-
-		// 如果当前 Pod 的数据得分更高，则更新最佳 Pod
-		if dataScore > highestScore {
-			highestScore = dataScore
-			bestSlave = &pod
-		}
-	}
-	// 如果没有找到合适的从库，则返回错误
-	if bestSlave == nil {
-		return "", nil, fmt.Errorf("no suitable slave found to be promoted to master")
-	}
-
-	// 确定新的主库名称
-	newMasterName := bestSlave.Name
-	var remainingSlaves []string
-
-	// 过滤掉新的主库，获取剩余的从库
-	for _, pod := range slavePodList {
-		if pod.Name != newMasterName {
-			remainingSlaves = append(remainingSlaves, pod.Name)
-		}
-	}
-	logger.Info("成功选举新主库", "新主库", newMasterName, "其余从库", remainingSlaves)
-	return newMasterName, remainingSlaves, nil
-
-}
-
-// 计算数据得分的函数
-func (r *YellowTangReconciler) getDataScore(ctx context.Context, pod *corev1.Pod) (float64, error) {
-	// 获取主库的 GTID 集合快照
-	masterGTIDSet := r.MasterGTIDSnapshot
-
-	// 获取从库的 GTID 集合
-	slaveGTIDSet, err := r.getSlaveGTIDSet(ctx, pod)
-	if err != nil {
-		return 0.0, fmt.Errorf("failed to get slave GTID set: %v", err)
-	}
-
-	// 计算 GTID 完整度得分
-	gtidScore := r.calculateGTIDScore(masterGTIDSet, slaveGTIDSet)
-
-	// 获取从库的数据量
-	dataSize, err := r.getDataSize(ctx, pod)
-	if err != nil {
-		return 0.0, fmt.Errorf("failed to get data size: %v", err)
-	}
-
-	// 计算数据量得分
-	dataScore := r.calculateDataScore(dataSize)
-
-	// 合成最终得分
-	finalScore := gtidScore + dataScore
-
-	return finalScore, nil
-}
-
-// 获取主库的 GTID 集合
-func (r *YellowTangReconciler) getMasterGTIDSet(ctx context.Context, pod *corev1.Pod) (string, error) {
-	masterCommand := "mysql -uroot -p%s -e \"SHOW MASTER STATUS\\G\" | grep 'Executed_Gtid_Set:' | awk '{print $2}'"
-	command := fmt.Sprintf(masterCommand, MySQLPassword)
-	_, err := r.execCommandOnPod(pod, command)
-	if err != nil {
-		return "", err
-	}
-	return "", nil
-}
-
-// 获取从库的 GTID 集合
-func (r *YellowTangReconciler) getSlaveGTIDSet(ctx context.Context, pod *corev1.Pod) (string, error) {
-	slaveCommand := "mysql -uroot -p%s -e \"SHOW SLAVE STATUS\\G\" | grep 'Retrieved_Gtid_Set:' | awk '{print $2}'"
-	command := fmt.Sprintf(slaveCommand, MySQLPassword)
-	_, err := r.execCommandOnPod(pod, command)
-	if err != nil {
-		return "", err
-	}
-	return "", nil
-}
-
-// 计算 GTID 完整度得分
-func (r *YellowTangReconciler) calculateGTIDScore(masterGTIDSet, slaveGTIDSet string) float64 {
-	// 计算 GTID 完整度得分的逻辑
-	// 例如，可以基于主库和从库的 GTID 集合的差异计算得分
-	if masterGTIDSet == "" || slaveGTIDSet == "" {
-		return 0.0
-	}
-
-	// 这里假设得分与从库的 GTID 集合包含主库的 GTID 集合的比例有关
-	masterGTIDs := strings.Split(masterGTIDSet, ",")
-	slaveGTIDs := strings.Split(slaveGTIDSet, ",")
-
-	// 简单示例：计算从库包含的 GTID 数量
-	count := 0
-	for _, masterGTID := range masterGTIDs {
-		for _, slaveGTID := range slaveGTIDs {
-			if masterGTID == slaveGTID {
-				count++
-				break
-			}
-		}
-	}
-
-	// 得分：包含的 GTID 数量占主库 GTID 数量的比例
-	return float64(count) / float64(len(masterGTIDs))
-}
-
-// 获取从库的数据量
-func (r *YellowTangReconciler) getDataSize(ctx context.Context, pod *corev1.Pod) (int64, error) {
-	// 获取 MySQL 数据目录的路径
-	// 这里使用了一个假设的默认路径，我们采用的容器中固定目录就是这个
-	dataDirPath := "/var/lib/mysql"
-
-	// 使用 du 命令计算数据目录的大小
-	dataSizeCommand := fmt.Sprintf("du -sb %s | awk '{print $1}'", dataDirPath)
-	output, err := r.execCommandOnPod(pod, dataSizeCommand)
-	if err != nil {
-		return 0, err
-	}
-
-	// 解析数据大小
-	dataSize, err := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
-	if err != nil {
-		return 0, err
-	}
-
-	return dataSize, nil
-}
-
-// 计算数据量得分
-func (r *YellowTangReconciler) calculateDataScore(dataSize int64) float64 {
-	// 计算数据量得分的逻辑
-	// 这里简单地将数据大小作为得分值
-	return float64(dataSize)
-}
+package controller
+
+import (
+	"context"
+	"fmt"
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/gtid"
+	"yellowtang/internal/mysql"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// 选主逻辑：
+/*
+MHA数据一致性和选择
+GTID模式：MHA会比较每个从库的GTID集合。选择包含主库GTID率高的的从库作为新的主库。
+从库的 GTID 集合取 Executed_Gtid_Set ∪ Retrieved_Gtid_Set，已经拉到 relay log 但还没回放的事务提升后也会执行。
+1. 缺少主库快照中的事务越少越好
+2. 缺少的一样多时，事务总数多的优先
+*/
+func (r *YellowTangReconciler) electNewMaster(ctx context.Context, tang *appsv1.YellowTang) (string, []string, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("开始选举新主...")
+
+	labels := roleLabels(tang, "slave")
+	slavePodList, err := r.getPodByLabels(labels, ctx, tang)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list slave pods: %v", err)
+	}
+	_slavePodNameList := []string{}
+	for _, pod := range slavePodList {
+		_slavePodNameList = append(_slavePodNameList, pod.Name)
+	}
+	logger.Info("所有从pod", "Pod", _slavePodNameList)
+
+	// 主库挂掉前最后一次采样到的 GTID，没有快照时只比较事务总数
+	masterGTIDSet := gtid.Set{}
+	if snapshot := tang.Status.MasterGTIDSnapshot; snapshot != nil {
+		logger.Info("使用主库 GTID 快照选主", "主库", snapshot.Master, "采样时间", snapshot.Time.Time)
+		if masterGTIDSet, err = gtid.Parse(snapshot.GTIDSet); err != nil {
+			logger.Error(err, "主库 GTID 快照无法解析，只按事务总数选主")
+			masterGTIDSet = gtid.Set{}
+		}
+	}
+
+	// 初始化选举变量
+	var bestSlave *corev1.Pod
+	var bestScore gtidScore
+
+	// 遍历所有从库 Pod
+	for i := range slavePodList {
+		pod := &slavePodList[i]
+		// 确保 Pod 健康，标签还没有更新的延迟从库也不参与选主
+		if !isPodHealthy(*pod) || isDelayedReplica(pod.Name, tang) {
+			continue
+		}
+
+		// 计算 GTID 得分
+		score, err := r.getGTIDScore(ctx, pod, masterGTIDSet, tang)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to get gtid score for pod %s: %v", pod.Name, err)
+		}
+		logger.Info("从库 GTID 得分", "Pod", pod.Name, "缺少的事务", score.Missing, "事务总数", score.Total)
+
+		// 如果当前 Pod 的得分更高，则更新最佳 Pod
+		if bestSlave == nil || score.betterThan(bestScore) {
+			bestScore = score
+			bestSlave = pod
+		}
+	}
+	// 如果没有找到合适的从库，则返回错误
+	if bestSlave == nil {
+		return "", nil, fmt.Errorf("no suitable slave found to be promoted to master")
+	}
+	if bestScore.Missing > 0 {
+		logger.Info("新主库缺少主库快照中的事务", "Pod", bestSlave.Name, "缺少的事务", bestScore.Missing)
+	}
+
+	// 确定新的主库名称
+	newMasterName := bestSlave.Name
+	var remainingSlaves []string
+
+	// 过滤掉新的主库，获取剩余的从库
+	for _, pod := range slavePodList {
+		if pod.Name != newMasterName {
+			remainingSlaves = append(remainingSlaves, pod.Name)
+		}
+	}
+	logger.Info("成功选举新主库", "新主库", newMasterName, "其余从库", remainingSlaves)
+	return newMasterName, remainingSlaves, nil
+
+}
+
+// 候选从库的 GTID 得分
+type gtidScore struct {
+	// 主库快照中从库没有的事务数
+	Missing int64
+	// 从库的事务总数
+	Total int64
+}
+
+func (s gtidScore) betterThan(other gtidScore) bool {
+	if s.Missing != other.Missing {
+		return s.Missing < other.Missing
+	}
+	return s.Total > other.Total
+}
+
+// 计算从库的 GTID 得分
+func (r *YellowTangReconciler) getGTIDScore(ctx context.Context, pod *corev1.Pod, masterGTIDSet gtid.Set, tang *appsv1.YellowTang) (gtidScore, error) {
+	slaveGTIDSet, err := r.getSlaveGTIDSet(ctx, pod, tang)
+	if err != nil {
+		return gtidScore{}, fmt.Errorf("failed to get slave GTID set: %v", err)
+	}
+	return calculateGTIDScore(masterGTIDSet, slaveGTIDSet), nil
+}
+
+// 计算 GTID 得分
+func calculateGTIDScore(masterGTIDSet, slaveGTIDSet gtid.Set) gtidScore {
+	return gtidScore{
+		Missing: masterGTIDSet.Subtract(slaveGTIDSet).Count(),
+		Total:   slaveGTIDSet.Count(),
+	}
+}
+
+// 获取主库的 GTID 集合
+func (r *YellowTangReconciler) getMasterGTIDSet(ctx context.Context, pod *corev1.Pod, tang *appsv1.YellowTang) (gtid.Set, error) {
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+	masterStatus, err := mysql.GetMasterStatus(ctx, r.SQL, rootTarget(pod, creds.RootPassword))
+	if err != nil {
+		return nil, err
+	}
+	return gtid.Parse(masterStatus.ExecutedGtidSet)
+}
+
+// 获取从库的 GTID 集合：已经执行的加上已经拉取到 relay log 的
+func (r *YellowTangReconciler) getSlaveGTIDSet(ctx context.Context, pod *corev1.Pod, tang *appsv1.YellowTang) (gtid.Set, error) {
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+	replica, err := r.getReplicaStatus(ctx, pod, creds)
+	if err != nil {
+		return nil, err
+	}
+	if replica == nil {
+		// 没有配置复制，只看自己执行过的事务
+		executed, err := mysql.GetExecutedGTIDSet(ctx, r.SQL, rootTarget(pod, creds.RootPassword))
+		if err != nil {
+			return nil, err
+		}
+		return gtid.Parse(executed)
+	}
+
+	executed, err := gtid.Parse(replica.ExecutedGtidSet)
+	if err != nil {
+		return nil, err
+	}
+	retrieved, err := gtid.Parse(replica.RetrievedGtidSet)
+	if err != nil {
+		return nil, err
+	}
+	return executed.Union(retrieved), nil
+}
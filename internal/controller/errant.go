@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/gtid"
+	"yellowtang/internal/mysql"
+)
+
+// InjectEmpty 策略一次最多注入的空事务数，超过时只报告，避免长时间占用主库
+const maxInjectedTransactions = 1000
+
+// 从库上发现 errant 事务时的处理方式
+func errantTransactionPolicy(tang *appsv1.YellowTang) appsv1.ErrantTransactionPolicy {
+	if tang.Spec.ErrantTransactionPolicy == "" {
+		return appsv1.ErrantTransactionPolicyReport
+	}
+	return tang.Spec.ErrantTransactionPolicy
+}
+
+// status 中每个从库的 errant 事务
+func errantGTIDSetsByPod(tang *appsv1.YellowTang) map[string]string {
+	errant := map[string]string{}
+	for _, replica := range tang.Status.Replicas {
+		if replica.ErrantGtidSet != "" {
+			errant[replica.Name] = replica.ErrantGtidSet
+		}
+	}
+	return errant
+}
+
+// 去掉被隔离的 pod
+func withoutFencedPods(pods []corev1.Pod, tang *appsv1.YellowTang) []corev1.Pod {
+	result := []corev1.Pod{}
+	for i := range pods {
+		if !isFencedPod(&pods[i], tang) {
+			result = append(result, pods[i])
+		}
+	}
+	return result
+}
+
+// 计算每个从库执行过、主库没有的事务，写入 replicaStatuses
+// 必须在读完所有从库的 Executed_Gtid_Set 之后调用
+func (r *YellowTangReconciler) computeErrantGTIDSets(ctx context.Context, masterPodName string, replicaStatuses []appsv1.ReplicaStatus, creds *mysqlCredentials, tang *appsv1.YellowTang) error {
+	masterPod, _, err := r.getPodAndCredentials(masterPodName, ctx, tang)
+	if err != nil {
+		return err
+	}
+	masterExecuted, err := mysql.GetExecutedGTIDSet(ctx, r.SQL, rootTarget(masterPod, creds.RootPassword))
+	if err != nil {
+		return fmt.Errorf("failed to read gtid_executed on master %s: %v", masterPodName, err)
+	}
+	masterSet, err := gtid.Parse(masterExecuted)
+	if err != nil {
+		return err
+	}
+
+	for i := range replicaStatuses {
+		replicaSet, err := gtid.Parse(replicaStatuses[i].ExecutedGtidSet)
+		if err != nil {
+			return fmt.Errorf("failed to parse gtid set of %s: %v", replicaStatuses[i].Name, err)
+		}
+		replicaStatuses[i].ErrantGtidSet = replicaSet.Subtract(masterSet).String()
+	}
+	return nil
+}
+
+// 按 spec.errantTransactionPolicy 处理从库上的 errant 事务
+// previous 是本次检测之前的 errant 事务，只有发生变化时才记录 Event
+func (r *YellowTangReconciler) handleErrantTransactions(ctx context.Context, masterPodName string, previous map[string]string, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	errantByPod := errantGTIDSetsByPod(tang)
+	if len(errantByPod) == 0 {
+		setCondition(tang, appsv1.ConditionReplicasConsistent, metav1.ConditionTrue, "NoErrantTransactions", "no replica has transactions missing on the master")
+		return nil
+	}
+
+	podNames := make([]string, 0, len(errantByPod))
+	for name := range errantByPod {
+		podNames = append(podNames, name)
+	}
+	sort.Strings(podNames)
+
+	messages := []string{}
+	for _, name := range podNames {
+		messages = append(messages, fmt.Sprintf("%s: %s", name, errantByPod[name]))
+		if previous[name] != errantByPod[name] {
+			logger.Info("从库上有主库没有的事务", "Pod", name, "errant", errantByPod[name])
+			r.Recorder.Eventf(tang, corev1.EventTypeWarning, "ErrantTransactions",
+				"%s has transactions that are missing on master %s: %s", name, masterPodName, errantByPod[name])
+		}
+	}
+	setCondition(tang, appsv1.ConditionReplicasConsistent, metav1.ConditionFalse, "ErrantTransactions", strings.Join(messages, "; "))
+
+	switch errantTransactionPolicy(tang) {
+	case appsv1.ErrantTransactionPolicyInjectEmpty:
+		return r.injectEmptyTransactions(ctx, masterPodName, errantByPod, tang)
+	case appsv1.ErrantTransactionPolicyQuarantine:
+		return r.quarantineErrantReplicas(ctx, podNames, errantByPod, tang)
+	}
+	return nil
+}
+
+// 在主库上注入和 errant 事务同样 GTID 的空事务
+// 注入后主库的 GTID 集合包含从库的，之后选主和重新加入都不会再把它们当成 errant
+func (r *YellowTangReconciler) injectEmptyTransactions(ctx context.Context, masterPodName string, errantByPod map[string]string, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	errant := gtid.Set{}
+	for _, set := range errantByPod {
+		parsed, err := gtid.Parse(set)
+		if err != nil {
+			return err
+		}
+		errant = errant.Union(parsed)
+	}
+
+	if count := errant.Count(); count > maxInjectedTransactions {
+		r.Recorder.Eventf(tang, corev1.EventTypeWarning, "InjectEmptySkipped",
+			"%d errant transactions exceed the limit of %d, not injecting empty transactions on %s", count, maxInjectedTransactions, masterPodName)
+		return nil
+	}
+
+	masterPod, creds, err := r.getPodAndCredentials(masterPodName, ctx, tang)
+	if err != nil {
+		return err
+	}
+
+	// SET GTID_NEXT 只对当前会话生效，所有语句必须在同一个会话中执行
+	statements := []string{}
+	for _, g := range errant.GTIDs() {
+		statements = append(statements, fmt.Sprintf("SET GTID_NEXT = %s", mysql.QuoteString(g)), "BEGIN", "COMMIT")
+	}
+	statements = append(statements, "SET GTID_NEXT = 'AUTOMATIC'")
+	if err := r.execSQL(ctx, masterPod, creds, statements...); err != nil {
+		return fmt.Errorf("failed to inject empty transactions on %s: %v", masterPodName, err)
+	}
+
+	logger.Info("已在主库上注入空事务", "主库", masterPodName, "gtid", errant.String())
+	r.Recorder.Eventf(tang, corev1.EventTypeNormal, "InjectedEmptyTransactions",
+		"injected empty transactions %s on master %s", errant.String(), masterPodName)
+	return nil
+}
+
+// 隔离有 errant 事务的从库，标记需要重新克隆
+func (r *YellowTangReconciler) quarantineErrantReplicas(ctx context.Context, podNames []string, errantByPod map[string]string, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	for _, name := range podNames {
+		if fenced := findFencedPod(tang, name); fenced != nil && fenced.NeedsReclone {
+			continue
+		}
+		pod, creds, err := r.getPodAndCredentials(name, ctx, tang)
+		if err != nil {
+			return err
+		}
+
+		// 停掉复制并设置只读，保留现场等待重新克隆
+		if err := r.execSQL(ctx, pod, creds, "STOP SLAVE", "SET GLOBAL super_read_only = ON"); err != nil {
+			logger.Info("隔离从库时设置只读失败", "Pod", name, "错误", err)
+		}
+		if err := r.labelPod(pod, roleFenced, ctx, tang); err != nil {
+			return err
+		}
+		recordFencedPod(tang, name, appsv1.FencingMethodReadOnly)
+		fenced := findFencedPod(tang, name)
+		fenced.ErrantGtidSet = errantByPod[name]
+		fenced.NeedsReclone = true
+
+		logger.Info("已隔离有 errant 事务的从库", "Pod", name)
+		r.Recorder.Eventf(tang, corev1.EventTypeWarning, "ReplicaQuarantined",
+			"%s was quarantined because of errant transactions %s and must be re-cloned", name, errantByPod[name])
+	}
+	return nil
+}
+
+// 确保 role=slave 的 pod 都开启了 super_read_only
+func (r *YellowTangReconciler) enforceSlaveReadOnly(ctx context.Context, slavePods []corev1.Pod, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for i := range slavePods {
+		pod := &slavePods[i]
+		if !isSlaveRole(pod.Labels["role"]) {
+			continue
+		}
+
+		target := rootTarget(pod, creds.RootPassword)
+		value, err := mysql.GetGlobalVariable(ctx, r.SQL, target, "super_read_only")
+		if err != nil {
+			failed = append(failed, pod.Name)
+			continue
+		}
+		if value == "ON" {
+			continue
+		}
+
+		if err := r.SQL.Exec(ctx, target, "SET GLOBAL super_read_only = ON"); err != nil {
+			failed = append(failed, pod.Name)
+			continue
+		}
+		logger.Info("从库未开启只读，已重新开启", "Pod", pod.Name)
+		r.Recorder.Eventf(tang, corev1.EventTypeWarning, "ReadOnlyEnforced", "super_read_only was off on replica %s and has been turned on", pod.Name)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to enforce super_read_only on %s", strings.Join(failed, ","))
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/gtid"
+	"yellowtang/internal/mysql"
+)
+
+const (
+	// 未设置 spec.externalSource.port 时的端口
+	defaultExternalSourcePort = 3306
+
+	// promote 时每次调谐等待 SQL 线程应用 relay log 的时间，超时后重新入队
+	externalSourcePromoteWait = time.Second
+)
+
+// 主库是否还在从外部 MySQL 复制：设置了 spec.externalSource，并且还没有完成 promote
+func externalReplicationActive(tang *appsv1.YellowTang) bool {
+	if tang.Spec.ExternalSource == nil || topology(tang) == appsv1.TopologyGroupReplication {
+		return false
+	}
+	return tang.Status.ExternalSource == nil || !tang.Status.ExternalSource.Promoted
+}
+
+// 新主库是否要从外部 MySQL 复制：请求了 promote 之后不再重新建立复制
+func externalReplicationWanted(tang *appsv1.YellowTang) bool {
+	return externalReplicationActive(tang) && !tang.Spec.ExternalSource.Promote
+}
+
+// 外部 MySQL 的端口
+func externalSourcePort(tang *appsv1.YellowTang) int {
+	if tang.Spec.ExternalSource.Port > 0 {
+		return int(tang.Spec.ExternalSource.Port)
+	}
+	return defaultExternalSourcePort
+}
+
+// 从 spec.externalSource.secretName 读取外部 MySQL 的复制账号
+func (r *YellowTangReconciler) getExternalSourceCredentials(ctx context.Context, tang *appsv1.YellowTang) (string, string, error) {
+	secretName := tang.Spec.ExternalSource.SecretName
+	secret, err := r.getSecret(client.ObjectKey{Namespace: tang.Namespace, Name: secretName}, ctx, tang)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get external source secret %s: %v", secretName, err)
+	}
+	user := string(secret.Data[SecretKeyReplicationUser])
+	password := string(secret.Data[SecretKeyReplicationPassword])
+	if user == "" || password == "" {
+		return "", "", fmt.Errorf("secret %s must contain %s and %s", secretName, SecretKeyReplicationUser, SecretKeyReplicationPassword)
+	}
+	return user, password, nil
+}
+
+// 让主库从外部 MySQL 复制，主库保持只读，只接受外部 MySQL 的写入
+func (r *YellowTangReconciler) startExternalReplication(ctx context.Context, masterPod *corev1.Pod, creds *mysqlCredentials, tang *appsv1.YellowTang) error {
+	user, password, err := r.getExternalSourceCredentials(ctx, tang)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("主库从外部 MySQL 复制", "主库", masterPod.Name, "外部 MySQL", tang.Spec.ExternalSource.Host)
+	if err := r.execSQL(ctx, masterPod, creds,
+		"STOP SLAVE",
+		fmt.Sprintf("CHANGE MASTER TO MASTER_HOST=%s, MASTER_PORT=%d, MASTER_USER=%s, MASTER_PASSWORD=%s, MASTER_AUTO_POSITION=1, MASTER_DELAY=0",
+			mysql.QuoteString(tang.Spec.ExternalSource.Host), externalSourcePort(tang), mysql.QuoteString(user), mysql.QuoteString(password)),
+		"START SLAVE",
+		"SET GLOBAL super_read_only = ON",
+	); err != nil {
+		return fmt.Errorf("failed to replicate %s from external source %s: %v", masterPod.Name, tang.Spec.ExternalSource.Host, err)
+	}
+	return nil
+}
+
+// 每次调谐确认主库还在从外部 MySQL 复制，并把复制状态写入 status.externalSource
+// 复制来源不是外部 MySQL 时重新配置，例如故障切换或者计划内切换之后；复制线程出错时只报告
+// spec.externalSource.promote 为 true 时停止复制，主库开始接受写入
+func (r *YellowTangReconciler) reconcileExternalSource(ctx context.Context, masterPodName string, tang *appsv1.YellowTang) (ctrl.Result, error) {
+	if !externalReplicationActive(tang) {
+		return ctrl.Result{}, nil
+	}
+	masterPod, creds, err := r.getPodAndCredentials(masterPodName, ctx, tang)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	source := tang.Spec.ExternalSource
+
+	replica, err := r.getReplicaStatus(ctx, masterPod, creds)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to read replica status on %s: %v", masterPodName, err)
+	}
+	fromSource := replica != nil && replica.MasterHost == source.Host && replica.MasterPort == externalSourcePort(tang)
+	// 请求了 promote 但主库已经没有从外部 MySQL 复制，说明 RESET SLAVE ALL 已经执行过，只是 status 没有写回
+	if source.Promote && !fromSource {
+		return ctrl.Result{}, r.markExternalSourcePromoted(ctx, masterPodName, tang)
+	}
+	if !fromSource {
+		if err := r.startExternalReplication(ctx, masterPod, creds, tang); err != nil {
+			return ctrl.Result{}, err
+		}
+		if replica, err = r.getReplicaStatus(ctx, masterPod, creds); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to read replica status on %s: %v", masterPodName, err)
+		}
+	}
+
+	status := &appsv1.ExternalSourceStatus{Host: source.Host}
+	if replica != nil {
+		status.IOThreadRunning = replica.IOThreadRunning
+		status.SQLThreadRunning = replica.SQLThreadRunning
+		status.SecondsBehindSource = replica.SecondsBehindMaster
+		status.LastError = replica.LastError()
+	}
+	tang.Status.ExternalSource = status
+
+	if status.IOThreadRunning && status.SQLThreadRunning {
+		lag := "unknown"
+		if status.SecondsBehindSource != nil {
+			lag = fmt.Sprintf("%ds", *status.SecondsBehindSource)
+		}
+		setCondition(tang, appsv1.ConditionExternalReplication, metav1.ConditionTrue, "Replicating",
+			fmt.Sprintf("%s is replicating from %s, %s behind", masterPodName, source.Host, lag))
+	} else {
+		setCondition(tang, appsv1.ConditionExternalReplication, metav1.ConditionFalse, "ReplicationBroken",
+			fmt.Sprintf("%s is not replicating from %s: %s", masterPodName, source.Host, status.LastError))
+	}
+
+	if source.Promote {
+		return r.promoteFromExternalSource(ctx, masterPod, creds, replica, tang)
+	}
+	return ctrl.Result{}, nil
+}
+
+// 停止从外部 MySQL 复制，主库开始接受写入
+// 1. 先停止 IO 线程，relay log 不再增加，这时的 Retrieved_Gtid_Set 才是最终要应用的事务
+// 2. 等 SQL 线程应用完这些事务，没有应用完时保持 IO 线程停止，稍后重试
+// 3. 最后 RESET SLAVE ALL 并关闭只读
+func (r *YellowTangReconciler) promoteFromExternalSource(ctx context.Context, masterPod *corev1.Pod, creds *mysqlCredentials, replica *mysql.ReplicaStatus, tang *appsv1.YellowTang) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if replica.IOThreadRunning {
+		logger.Info("停止主库从外部 MySQL 拉取事务", "主库", masterPod.Name)
+		if err := r.execSQL(ctx, masterPod, creds, "STOP SLAVE IO_THREAD"); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to stop io thread on %s: %v", masterPod.Name, err)
+		}
+		var err error
+		if replica, err = r.getReplicaStatus(ctx, masterPod, creds); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to read replica status on %s: %v", masterPod.Name, err)
+		}
+		if replica == nil {
+			return ctrl.Result{}, fmt.Errorf("replication on %s disappeared while stopping the io thread", masterPod.Name)
+		}
+	}
+
+	if replica.RetrievedGtidSet != "" {
+		caughtUp, err := mysql.WaitForExecutedGTIDSet(ctx, r.SQL, rootTarget(masterPod, creds.RootPassword), replica.RetrievedGtidSet, externalSourcePromoteWait)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to wait for relay log on %s: %v", masterPod.Name, err)
+		}
+		if !caughtUp {
+			pending := replica.RetrievedGtidSet
+			if retrieved, err := gtid.Parse(replica.RetrievedGtidSet); err == nil {
+				if executed, err := gtid.Parse(replica.ExecutedGtidSet); err == nil {
+					pending = retrieved.Subtract(executed).String()
+				}
+			}
+			logger.Info("等待主库应用完外部 MySQL 的事务", "主库", masterPod.Name, "未应用的事务", pending)
+			setCondition(tang, appsv1.ConditionExternalReplication, metav1.ConditionTrue, "Promoting",
+				fmt.Sprintf("waiting for %s to apply %s before promotion", masterPod.Name, pending))
+			return ctrl.Result{RequeueAfter: podReadyRequeueInterval}, nil
+		}
+	}
+
+	if err := r.execSQL(ctx, masterPod, creds,
+		"STOP SLAVE",
+		"RESET SLAVE ALL",
+		"SET GLOBAL super_read_only = OFF",
+		"SET GLOBAL read_only = OFF",
+	); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to promote %s from external source: %v", masterPod.Name, err)
+	}
+	return ctrl.Result{}, r.markExternalSourcePromoted(ctx, masterPod.Name, tang)
+}
+
+// 记录 promote 完成，并立即写回 status
+// 写回失败时下一次调谐发现主库已经没有从外部 MySQL 复制，会再次标记，不会重新指向外部 MySQL
+func (r *YellowTangReconciler) markExternalSourcePromoted(ctx context.Context, masterPodName string, tang *appsv1.YellowTang) error {
+	now := metav1.Now()
+	tang.Status.ExternalSource = &appsv1.ExternalSourceStatus{
+		Host:         tang.Spec.ExternalSource.Host,
+		Promoted:     true,
+		PromotedTime: &now,
+	}
+	setCondition(tang, appsv1.ConditionExternalReplication, metav1.ConditionFalse, "Promoted",
+		fmt.Sprintf("%s stopped replicating from %s and accepts writes", masterPodName, tang.Spec.ExternalSource.Host))
+	if err := r.updateStatus(ctx, tang); err != nil {
+		return fmt.Errorf("failed to record promotion from external source: %v", err)
+	}
+	log.FromContext(ctx).Info("主库停止从外部 MySQL 复制", "主库", masterPodName)
+	r.Recorder.Eventf(tang, corev1.EventTypeNormal, "ExternalSourcePromoted", "%s stopped replicating from %s and accepts writes", masterPodName, tang.Spec.ExternalSource.Host)
+	return nil
+}
@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+const (
+	// 被隔离的旧主库的 role 标签，不会被 master-service、slave-service 选中，也不会参与选主
+	roleFenced = "fenced"
+	// 被 NetworkPolicy 隔离的 pod 带有该标签
+	LabelFenced = "yellowtang.kaxonliu.com/fenced"
+
+	// 未设置 spec.failover 时的默认值
+	defaultFailoverGracePeriod      = 30 * time.Second
+	defaultFailoverFailureThreshold = 3
+	// 主库不可用时重新检测的间隔，连续失败次数按这个间隔累计
+	failoverProbeInterval = 5 * time.Second
+	// 通过 sql 隔离旧主库的超时时间，超时按连不上处理
+	fencingSQLTimeout = 10 * time.Second
+)
+
+// 故障切换前主库需要持续不可用的时间
+func failoverGracePeriod(tang *appsv1.YellowTang) time.Duration {
+	if tang.Spec.Failover.GracePeriodSeconds > 0 {
+		return time.Duration(tang.Spec.Failover.GracePeriodSeconds) * time.Second
+	}
+	return defaultFailoverGracePeriod
+}
+
+// 故障切换前需要连续检测到主库不可用的次数
+func failoverFailureThreshold(tang *appsv1.YellowTang) int32 {
+	if tang.Spec.Failover.FailureThreshold > 0 {
+		return tang.Spec.Failover.FailureThreshold
+	}
+	return defaultFailoverFailureThreshold
+}
+
+// 旧主库连不上时的隔离方式
+func fencingPolicy(tang *appsv1.YellowTang) appsv1.FencingPolicy {
+	if tang.Spec.Failover.FencingPolicy == "" {
+		return appsv1.FencingPolicyDelete
+	}
+	return tang.Spec.Failover.FencingPolicy
+}
+
+// 记录一次主库不可用，返回是否已经满足故障切换的条件
+// 同一个检测间隔内的多次调谐只算一次
+func recordMasterFailure(tang *appsv1.YellowTang) bool {
+	now := metav1.Now()
+	failure := tang.Status.MasterFailure
+	if failure == nil {
+		failure = &appsv1.MasterFailureStatus{Since: now, LastProbeTime: now, ConsecutiveFailures: 1}
+		tang.Status.MasterFailure = failure
+	} else if now.Sub(failure.LastProbeTime.Time) >= failoverProbeInterval {
+		failure.ConsecutiveFailures++
+		failure.LastProbeTime = now
+	}
+
+	return failure.ConsecutiveFailures >= failoverFailureThreshold(tang) &&
+		now.Sub(failure.Since.Time) >= failoverGracePeriod(tang)
+}
+
+// 找出需要隔离的旧主库：带 role=master 标签的 pod，以及 status 中记录的主库
+func (r *YellowTangReconciler) getOldMasterPods(ctx context.Context, tang *appsv1.YellowTang) ([]corev1.Pod, error) {
+	oldMasters, err := r.getAllPodByLabels(roleLabels(tang, "master"), ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+
+	if tang.Status.MasterPod == "" {
+		return oldMasters, nil
+	}
+	for _, pod := range oldMasters {
+		if pod.Name == tang.Status.MasterPod {
+			return oldMasters, nil
+		}
+	}
+	pod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: tang.Status.MasterPod}, ctx, tang)
+	if errors.IsNotFound(err) {
+		return oldMasters, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pod.DeletionTimestamp == nil && pod.Labels["role"] != roleFenced {
+		oldMasters = append(oldMasters, *pod)
+	}
+	return oldMasters, nil
+}
+
+// 提升新主库之前隔离旧主库，防止脑裂
+// 1. 能连上就设置 super_read_only，旧主库之后可以作为从库重新加入
+// 2. 连不上就按 spec.failover.fencingPolicy 删除 pod 或者用 NetworkPolicy 隔离
+// 两种情况都会把 role 标签改成 fenced，不再被 master-service 选中
+// 任何一个旧主库隔离失败都返回错误，不能继续提升新主库
+func (r *YellowTangReconciler) fenceFailedMaster(ctx context.Context, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	oldMasters, err := r.getOldMasterPods(ctx, tang)
+	if err != nil {
+		return fmt.Errorf("failed to list old master pods: %v", err)
+	}
+
+	for i := range oldMasters {
+		pod := &oldMasters[i]
+		method, err := r.fencePod(ctx, pod, tang)
+		if err != nil {
+			return fmt.Errorf("failed to fence old master %s: %v", pod.Name, err)
+		}
+		logger.Info("已隔离旧主库", "Pod", pod.Name, "方式", method)
+		recordFencedPod(tang, pod.Name, method)
+	}
+	return nil
+}
+
+// 隔离一个旧主库 pod
+func (r *YellowTangReconciler) fencePod(ctx context.Context, pod *corev1.Pod, tang *appsv1.YellowTang) (appsv1.FencingMethod, error) {
+	logger := log.FromContext(ctx)
+
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return "", err
+	}
+
+	readOnly := false
+	if pod.Status.PodIP != "" {
+		sqlCtx, cancel := context.WithTimeout(ctx, fencingSQLTimeout)
+		err := r.execSQL(sqlCtx, pod, creds, "SET GLOBAL super_read_only = ON")
+		cancel()
+		if err != nil {
+			logger.Info("旧主库无法设置只读", "Pod", pod.Name, "错误", err)
+		}
+		readOnly = err == nil
+	}
+
+	// 先从 master-service 中摘掉
+	if err := r.labelPod(pod, roleFenced, ctx, tang); err != nil {
+		return "", err
+	}
+	if readOnly {
+		return appsv1.FencingMethodReadOnly, nil
+	}
+
+	switch fencingPolicy(tang) {
+	case appsv1.FencingPolicyNetworkPolicy:
+		if err := r.getorCreateFencingNetworkPolicy(ctx, tang); err != nil {
+			return "", err
+		}
+		pod.Labels[LabelFenced] = "true"
+		if err := r.Update(ctx, pod); err != nil {
+			return "", fmt.Errorf("failed to update pod %s: %v", pod.Name, err)
+		}
+		return appsv1.FencingMethodNetworkPolicy, nil
+	default:
+		if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to delete pod %s: %v", pod.Name, err)
+		}
+		return appsv1.FencingMethodDeleted, nil
+	}
+}
+
+// 在 status 中记录被隔离的 pod
+func recordFencedPod(tang *appsv1.YellowTang, name string, method appsv1.FencingMethod) {
+	fenced := appsv1.FencedPod{Name: name, Method: method, Time: metav1.Now()}
+	for i := range tang.Status.FencedPods {
+		if tang.Status.FencedPods[i].Name == name {
+			tang.Status.FencedPods[i] = fenced
+			return
+		}
+	}
+	tang.Status.FencedPods = append(tang.Status.FencedPods, fenced)
+}
+
+// 隔离用的 NetworkPolicy 名字，每个集群一个
+func fencingNetworkPolicyName(tang *appsv1.YellowTang) string {
+	return fmt.Sprintf("%s-fence", tang.Name)
+}
+
+// NetworkPolicy 选中的 pod：本集群中带有 fenced 标签的 pod
+func fencedPodLabels(tang *appsv1.YellowTang) map[string]string {
+	selectLabels := clusterLabels(tang)
+	selectLabels[LabelFenced] = "true"
+	return selectLabels
+}
+
+// 创建拒绝所有出入流量的 NetworkPolicy，选中带有 fenced 标签的 pod
+func (r *YellowTangReconciler) getorCreateFencingNetworkPolicy(ctx context.Context, tang *appsv1.YellowTang) error {
+	policy := networkingv1.NetworkPolicy{}
+	policyKey := client.ObjectKey{Namespace: tang.Namespace, Name: fencingNetworkPolicyName(tang)}
+	err := r.Get(ctx, policyKey, &policy)
+	if err == nil {
+		return checkOwnership(&policy, tang)
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	ownerRef := metav1.OwnerReference{
+		APIVersion: MysqlClusterAPIVersion,
+		Kind:       MysqlClusterKind,
+		Name:       tang.Name,
+		UID:        tang.UID,
+		Controller: func(b bool) *bool { return &b }(true),
+	}
+	policy = networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      policyKey.Name,
+			Namespace: tang.Namespace,
+			Labels:    clusterLabels(tang),
+			OwnerReferences: []metav1.OwnerReference{
+				ownerRef,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: fencedPodLabels(tang),
+			},
+			// 不写任何规则即拒绝所有出入流量
+			PolicyTypes: []networkingv1.PolicyType{
+				networkingv1.PolicyTypeIngress,
+				networkingv1.PolicyTypeEgress,
+			},
+		},
+	}
+	return r.Create(ctx, &policy)
+}
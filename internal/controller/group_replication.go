@@ -0,0 +1,431 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/gtid"
+	"yellowtang/internal/mysql"
+)
+
+const (
+	// 组复制成员之间通信的端口
+	groupReplicationPort = 33061
+
+	// 不在组中或者还没有恢复完成的 pod 的 role 标签，不会被 master-service 和 slave-service 选中
+	roleOffline = "offline"
+
+	groupMemberOnline     = "ONLINE"
+	groupMemberRecovering = "RECOVERING"
+	groupMemberOffline    = "OFFLINE"
+	groupMemberError      = "ERROR"
+	groupMemberPrimary    = "PRIMARY"
+)
+
+// 集群拓扑
+func topology(tang *appsv1.YellowTang) appsv1.Topology {
+	if tang.Spec.Topology == "" {
+		return appsv1.TopologyMasterSlave
+	}
+	return tang.Spec.Topology
+}
+
+// 所有 pod 相同的组复制配置，组名使用 CR 的 UID
+// 成员不随 mysqld 自动加入组，由 operator 判断是加入已有的组还是重新引导
+func groupReplicationConfig(tang *appsv1.YellowTang) map[string]string {
+	return map[string]string{
+		"group-replication-group-name":                       string(tang.UID),
+		"group-replication-start-on-boot":                    "off",
+		"group-replication-single-primary-mode":              "on",
+		"group-replication-enforce-update-everywhere-checks": "off",
+		"binlog-checksum":                                    "NONE",
+	}
+}
+
+// 每个 pod 不同的组复制配置，成员之间通过无头 service 的 DNS 互相连接
+func groupReplicationPodConfig(podName string, tang *appsv1.YellowTang) map[string]string {
+	return map[string]string{
+		"report-host":                     podFQDN(podName, tang),
+		"group-replication-local-address": fmt.Sprintf("%s:%d", podFQDN(podName, tang), groupReplicationPort),
+	}
+}
+
+// 组复制的种子成员：spec.replicas 个 pod 的通信地址
+func groupSeeds(tang *appsv1.YellowTang) string {
+	seeds := []string{}
+	for i := 0; i < int(tang.Spec.Replicas); i++ {
+		seeds = append(seeds, fmt.Sprintf("%s:%d", podFQDN(mysqlPodName(tang, i), tang), groupReplicationPort))
+	}
+	return strings.Join(seeds, ",")
+}
+
+// MEMBER_HOST 是 report-host，即 pod 的 DNS 名字，取第一段作为 pod 名字
+func groupMemberPodName(host string, tang *appsv1.YellowTang) (string, bool) {
+	name, _, _ := strings.Cut(host, ".")
+	if _, ok := parsePodNo(name, tang); !ok {
+		return "", false
+	}
+	return name, true
+}
+
+// 让 pod 加入组复制，bootstrap 为 true 时由它引导一个新的组
+// 复制账号在每个成员上单独创建、不写 binlog，避免加入时出现组中没有的事务
+// 处于 ERROR 状态的成员先停止组复制再重新加入
+func (r *YellowTangReconciler) startGroupMember(ctx context.Context, pod *corev1.Pod, creds *mysqlCredentials, bootstrap bool, tang *appsv1.YellowTang) error {
+	user := mysql.QuoteString(creds.ReplicationUser)
+	password := mysql.QuoteString(creds.ReplicationPassword)
+	statements := []string{
+		"SET SQL_LOG_BIN = 0",
+		fmt.Sprintf("CREATE USER IF NOT EXISTS %s@'%%' IDENTIFIED BY %s", user, password),
+		fmt.Sprintf("GRANT REPLICATION SLAVE, BACKUP_ADMIN ON *.* TO %s@'%%'", user),
+		"SET SQL_LOG_BIN = 1",
+		fmt.Sprintf("CHANGE MASTER TO MASTER_USER=%s, MASTER_PASSWORD=%s FOR CHANNEL 'group_replication_recovery'", user, password),
+		fmt.Sprintf("SET GLOBAL group_replication_group_seeds = %s", mysql.QuoteString(groupSeeds(tang))),
+	}
+	if err := r.execSQL(ctx, pod, creds, statements...); err != nil {
+		return fmt.Errorf("failed to configure group replication on %s: %v", pod.Name, err)
+	}
+
+	state, err := r.getLocalGroupMemberState(ctx, pod, creds, tang)
+	if err != nil {
+		return err
+	}
+	if state == groupMemberError {
+		if err := r.execSQL(ctx, pod, creds, "STOP GROUP_REPLICATION"); err != nil {
+			return fmt.Errorf("failed to stop group replication on %s: %v", pod.Name, err)
+		}
+	}
+
+	if !bootstrap {
+		if err := r.execSQL(ctx, pod, creds, "START GROUP_REPLICATION"); err != nil {
+			return fmt.Errorf("failed to join %s to the group: %v", pod.Name, err)
+		}
+		return nil
+	}
+	// 引导失败时也要关掉 group_replication_bootstrap_group，否则之后加入时会引导出第二个组
+	err = r.execSQL(ctx, pod, creds,
+		"SET GLOBAL group_replication_bootstrap_group = ON",
+		"START GROUP_REPLICATION",
+		"SET GLOBAL group_replication_bootstrap_group = OFF",
+	)
+	if err != nil {
+		if resetErr := r.execSQL(ctx, pod, creds, "SET GLOBAL group_replication_bootstrap_group = OFF"); resetErr != nil {
+			log.FromContext(ctx).Info("关闭 group_replication_bootstrap_group 失败", "Pod", pod.Name, "错误", resetErr)
+		}
+		return fmt.Errorf("failed to bootstrap the group on %s: %v", pod.Name, err)
+	}
+	return nil
+}
+
+// pod 自己的组复制成员状态，没有开启组复制时为 OFFLINE
+func (r *YellowTangReconciler) getLocalGroupMemberState(ctx context.Context, pod *corev1.Pod, creds *mysqlCredentials, tang *appsv1.YellowTang) (string, error) {
+	members, err := mysql.GetGroupMembers(ctx, r.SQL, rootTarget(pod, creds.RootPassword))
+	if err != nil {
+		return "", fmt.Errorf("failed to read group members on %s: %v", pod.Name, err)
+	}
+	for _, member := range members {
+		if name, ok := groupMemberPodName(member.Host, tang); ok && name == pod.Name {
+			return member.State, nil
+		}
+	}
+	return groupMemberOffline, nil
+}
+
+// 初始化组复制：第一个 pod 引导组，其他 pod 依次加入
+func (r *YellowTangReconciler) initGroupReplication(ctx context.Context, tang *appsv1.YellowTang) (bool, error) {
+	logger := log.FromContext(ctx)
+
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < int(tang.Spec.Replicas); i++ {
+		pod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: mysqlPodName(tang, i)}, ctx, tang)
+		if err != nil {
+			return false, err
+		}
+		logger.Info("初始化组复制", "Pod", pod.Name, "引导", i == 0)
+		if err := r.startGroupMember(ctx, pod, creds, i == 0, tang); err != nil {
+			return false, err
+		}
+		role := "slave"
+		if i == 0 {
+			role = "master"
+		}
+		if err := r.labelPod(pod, role, ctx, tang); err != nil {
+			return false, err
+		}
+	}
+
+	masterPodName := mysqlPodName(tang, 0)
+	tang.Status.MasterPod = masterPodName
+	setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionTrue, "MasterReady", fmt.Sprintf("%s bootstrapped the group as primary", masterPodName))
+	return true, nil
+}
+
+// 从所有就绪的 pod 读取组成员，使用 ONLINE 成员最多的视图，key 是 pod 名字
+// 网络分区时少数派一侧看到的其他成员是 UNREACHABLE，不会被选中
+func (r *YellowTangReconciler) getGroupView(ctx context.Context, pods []corev1.Pod, creds *mysqlCredentials, tang *appsv1.YellowTang) map[string]mysql.GroupMember {
+	logger := log.FromContext(ctx)
+
+	view := map[string]mysql.GroupMember{}
+	viewOnline := 0
+	for i := range pods {
+		pod := &pods[i]
+		if !isPodHealthy(*pod) {
+			continue
+		}
+		members, err := mysql.GetGroupMembers(ctx, r.SQL, rootTarget(pod, creds.RootPassword))
+		if err != nil {
+			logger.Info("读取组成员失败", "Pod", pod.Name, "错误", err)
+			continue
+		}
+		current := map[string]mysql.GroupMember{}
+		online := 0
+		for _, member := range members {
+			name, ok := groupMemberPodName(member.Host, tang)
+			if !ok {
+				continue
+			}
+			current[name] = member
+			if member.State == groupMemberOnline {
+				online++
+			}
+		}
+		if online > viewOnline {
+			view, viewOnline = current, online
+		}
+	}
+	return view
+}
+
+// 组中没有 ONLINE 成员时重新引导
+// 只有所有 pod 都就绪、都不在组中时才引导，避免和看不到的另一半组形成两个组
+// 从 gtid 集合包含所有其他 pod 的 pod 引导，找不到这样的 pod 时需要手动处理
+func (r *YellowTangReconciler) bootstrapGroup(ctx context.Context, pods []corev1.Pod, creds *mysqlCredentials, tang *appsv1.YellowTang) (string, error) {
+	if len(pods) < int(tang.Spec.Replicas) {
+		return fmt.Sprintf("waiting for %d missing pods before bootstrapping the group", int(tang.Spec.Replicas)-len(pods)), nil
+	}
+	sets := map[string]gtid.Set{}
+	for i := range pods {
+		pod := &pods[i]
+		if !isPodHealthy(*pod) {
+			return fmt.Sprintf("waiting for %s to be ready before bootstrapping the group", pod.Name), nil
+		}
+		executed, err := mysql.GetExecutedGTIDSet(ctx, r.SQL, rootTarget(pod, creds.RootPassword))
+		if err != nil {
+			return "", fmt.Errorf("failed to read gtid_executed on %s: %v", pod.Name, err)
+		}
+		set, err := gtid.Parse(executed)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse gtid_executed of %s: %v", pod.Name, err)
+		}
+		sets[pod.Name] = set
+	}
+
+	var candidate *corev1.Pod
+	for i := range pods {
+		contains := true
+		for name, set := range sets {
+			if name != pods[i].Name && !sets[pods[i].Name].Contains(set) {
+				contains = false
+				break
+			}
+		}
+		if contains && (candidate == nil || pods[i].Name < candidate.Name) {
+			candidate = &pods[i]
+		}
+	}
+	if candidate == nil {
+		return "no member has all transactions of the others, bootstrap the group manually", nil
+	}
+
+	log.FromContext(ctx).Info("组中没有 ONLINE 成员，重新引导组", "Pod", candidate.Name)
+	r.Recorder.Eventf(tang, corev1.EventTypeWarning, "GroupBootstrapped", "no member of the group was online, bootstrapping the group on %s", candidate.Name)
+	if err := r.startGroupMember(ctx, candidate, creds, true, tang); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("bootstrapped the group on %s", candidate.Name), nil
+}
+
+// 组复制集群的检查，代替主从拓扑的主库检查和选主
+// 1. 从成员视图中找到组选出的主库，打上 master 标签，ONLINE 的其他成员打上 slave 标签
+// 2. 不在组中或者处于 ERROR 状态的 pod 重新加入，组中没有 ONLINE 成员时重新引导
+// 3. 成员状态写入 status.groupMembers，主库变化时记录 Event
+// 4. spec.mysqlConfig 和 pod 模板的变化按主从拓扑相同的方式滚动重启
+func (r *YellowTangReconciler) checkGroup(ctx context.Context, tang *appsv1.YellowTang) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("开始组复制状态检测...")
+
+	result := ctrl.Result{}
+	pods, err := r.getAllPodByLabels(clusterLabels(tang), ctx, tang)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	view := r.getGroupView(ctx, pods, creds, tang)
+	primary := ""
+	for name, member := range view {
+		if member.State == groupMemberOnline && member.Role == groupMemberPrimary {
+			primary = name
+		}
+	}
+
+	statusBefore := tang.Status.DeepCopy()
+	if !anyGroupMemberOnline(view) {
+		message, err := r.bootstrapGroup(ctx, pods, creds, tang)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		tang.Status.Phase = appsv1.ClusterPhaseDegraded
+		setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionFalse, "GroupOffline", message)
+		if err := r.updateStatus(ctx, tang); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: podReadyRequeueInterval}, nil
+	}
+
+	// 主库由组选举，pod 的 role 标签跟着组的视图走
+	for i := range pods {
+		pod := &pods[i]
+		member, ok := view[pod.Name]
+		role := roleOffline
+		switch {
+		case pod.Name == primary:
+			role = "master"
+		case ok && member.State == groupMemberOnline:
+			role = "slave"
+		}
+		if pod.Labels["role"] != role {
+			if err := r.labelPod(pod, role, ctx, tang); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	// 有主库时才加入，失去多数派时加入也会失败
+	joining := []string{}
+	if primary != "" {
+		for i := range pods {
+			pod := &pods[i]
+			member, ok := view[pod.Name]
+			if !isPodHealthy(*pod) || ok && member.State != groupMemberOffline && member.State != groupMemberError {
+				continue
+			}
+			logger.Info("pod 不在组中，重新加入", "Pod", pod.Name)
+			r.Recorder.Eventf(tang, corev1.EventTypeNormal, "JoiningGroup", "%s is not an online member of the group, rejoining it", pod.Name)
+			if err := r.startGroupMember(ctx, pod, creds, false, tang); err != nil {
+				logger.Error(err, "加入组失败", "Pod", pod.Name)
+			}
+			joining = append(joining, pod.Name)
+		}
+	}
+
+	members := make([]appsv1.GroupMemberStatus, 0, len(pods))
+	notOnline := []string{}
+	for _, pod := range pods {
+		status := appsv1.GroupMemberStatus{Name: pod.Name, State: groupMemberOffline}
+		if member, ok := view[pod.Name]; ok {
+			status.State, status.Role = member.State, member.Role
+		}
+		if status.State != groupMemberOnline {
+			notOnline = append(notOnline, fmt.Sprintf("%s (%s)", pod.Name, status.State))
+		}
+		members = append(members, status)
+	}
+	tang.Status.GroupMembers = members
+
+	if primary == "" {
+		tang.Status.Phase = appsv1.ClusterPhaseDegraded
+		setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionFalse, "NoPrimary",
+			"the group has no online primary, it may have lost its majority and need group_replication_force_members")
+		result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+	} else {
+		if tang.Status.MasterPod != "" && tang.Status.MasterPod != primary {
+			logger.Info("组选出了新的主库", "旧主库", tang.Status.MasterPod, "新主库", primary)
+			r.Recorder.Eventf(tang, corev1.EventTypeNormal, "PrimaryChanged", "the group elected %s as primary, previous primary was %s", primary, tang.Status.MasterPod)
+		}
+		tang.Status.MasterPod = primary
+		setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionTrue, "MasterReady", fmt.Sprintf("%s is the group primary serving %s", primary, masterServiceName(tang)))
+	}
+	if len(notOnline) == 0 {
+		setCondition(tang, appsv1.ConditionReplicationHealthy, metav1.ConditionTrue, "MembersOnline", "all pods are online members of the group")
+	} else {
+		setCondition(tang, appsv1.ConditionReplicationHealthy, metav1.ConditionFalse, "MembersNotOnline", fmt.Sprintf("not online: %s", strings.Join(notOnline, ",")))
+		result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+	}
+	if primary != "" && len(notOnline) == 0 && !podsReadyTimedOut(tang) {
+		tang.Status.Phase = appsv1.ClusterPhaseRunning
+	} else {
+		tang.Status.Phase = appsv1.ClusterPhaseDegraded
+	}
+	if len(joining) > 0 {
+		result = mergeResult(result, ctrl.Result{RequeueAfter: podReadyRequeueInterval})
+	}
+
+	// spec.mysqlConfig 在线生效，需要重启的配置和 pod 模板的变化一起滚动重启
+	if primary != "" {
+		configRestart, configResult, err := r.reconcileMySQLConfig(ctx, tang)
+		if err != nil {
+			logger.Error(err, "配置生效失败")
+			configResult = ctrl.Result{RequeueAfter: podReadyRequeueInterval}
+		}
+		result = mergeResult(result, configResult)
+		rolloutResult, err := r.reconcileRollout(ctx, primary, configRestart, tang)
+		if err != nil {
+			logger.Error(err, "滚动重启失败")
+			rolloutResult = ctrl.Result{RequeueAfter: podReadyRequeueInterval}
+		}
+		result = mergeResult(result, rolloutResult)
+	}
+	if !equality.Semantic.DeepEqual(statusBefore, &tang.Status) {
+		if err := r.updateStatus(ctx, tang); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return result, nil
+}
+
+// 视图中是否有 ONLINE 成员
+func anyGroupMemberOnline(view map[string]mysql.GroupMember) bool {
+	for _, member := range view {
+		if member.State == groupMemberOnline {
+			return true
+		}
+	}
+	return false
+}
+
+// 组复制时滚动重启的健康检查用成员状态代替从库复制状态
+// 不是 ONLINE 的成员在就绪期限内算作不可用，RECOVERING 的成员正在追赶数据，一直算作不可用
+func groupMemberProblem(podName string, tang *appsv1.YellowTang) (problem string, recovering bool) {
+	for _, member := range tang.Status.GroupMembers {
+		if member.Name != podName {
+			continue
+		}
+		switch member.State {
+		case groupMemberOnline:
+			return "", false
+		case groupMemberRecovering:
+			return "", true
+		default:
+			return fmt.Sprintf("%s is %s in the group", podName, member.State), false
+		}
+	}
+	return fmt.Sprintf("%s has not joined the group", podName), false
+}
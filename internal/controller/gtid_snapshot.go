@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+// 采样主库 GTID 的间隔
+const gtidSnapshotInterval = 10 * time.Second
+
+// 定期采样每个集群主库的 Executed_Gtid_Set，写入各自的 status.masterGTIDSnapshot
+// reconciler 被所有 YellowTang 共用，快照必须按集群保存，不能放在 reconciler 上
+type gtidSnapshotCollector struct {
+	r        *YellowTangReconciler
+	interval time.Duration
+}
+
+var _ manager.LeaderElectionRunnable = &gtidSnapshotCollector{}
+
+// 只有 leader 才采样，避免多个副本同时写 status
+func (c *gtidSnapshotCollector) NeedLeaderElection() bool {
+	return true
+}
+
+func (c *gtidSnapshotCollector) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.collect(ctx)
+		}
+	}
+}
+
+// 遍历所有集群采样一次，单个集群失败不影响其他集群
+func (c *gtidSnapshotCollector) collect(ctx context.Context) {
+	logger := log.FromContext(ctx).WithName("gtid-snapshot")
+
+	var tangList appsv1.YellowTangList
+	if err := c.r.List(ctx, &tangList); err != nil {
+		logger.Error(err, "获取集群列表失败")
+		return
+	}
+
+	for i := range tangList.Items {
+		tang := &tangList.Items[i]
+		if err := c.snapshot(ctx, tang); err != nil {
+			logger.Error(err, "采样主库 GTID 失败", "namespace", tang.Namespace, "name", tang.Name, "master", tang.Status.MasterPod)
+		}
+	}
+}
+
+// 采样一个集群的主库 GTID
+func (c *gtidSnapshotCollector) snapshot(ctx context.Context, tang *appsv1.YellowTang) error {
+	// 初始化未完成或正在切换时主库不确定，保留上一次的快照
+	if tang.Status.InitStep != appsv1.InitStepDone || tang.Status.MasterPod == "" ||
+		tang.Status.Phase == appsv1.ClusterPhaseFailingOver || tang.Status.Phase == appsv1.ClusterPhaseSwitchingOver {
+		return nil
+	}
+
+	masterPod := &corev1.Pod{}
+	if err := c.r.Get(ctx, client.ObjectKey{Namespace: tang.Namespace, Name: tang.Status.MasterPod}, masterPod); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	// 主库已经挂了，快照停在它最后一次健康时的位置
+	if !isPodHealthy(*masterPod) {
+		return nil
+	}
+
+	gtidSet, err := c.r.getMasterGTIDSet(ctx, masterPod, tang)
+	if err != nil {
+		return err
+	}
+
+	last := tang.Status.MasterGTIDSnapshot
+	if last != nil && last.Master == masterPod.Name && last.GTIDSet == gtidSet.String() {
+		return nil
+	}
+
+	// merge patch 只修改快照字段，不会覆盖调谐过程中写入的其他状态
+	patch := client.MergeFrom(tang.DeepCopy())
+	tang.Status.MasterGTIDSnapshot = &appsv1.GTIDSnapshot{
+		Master:  masterPod.Name,
+		GTIDSet: gtidSet.String(),
+		Time:    metav1.Now(),
+	}
+	return c.r.Status().Patch(ctx, tang, patch)
+}
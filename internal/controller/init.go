@@ -4,77 +4,183 @@ import (
 	"context"
 	"fmt"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	appsv1 "yellowtang/api/v1"
 )
 
+// 初始化步骤未完成时的重新排队间隔
+const initRequeueInterval = podReadyRequeueInterval
+
+// 初始化步骤的执行函数
+// 返回 true 表示该步骤已经完成，可以进入下一步
+// 返回 false 表示需要等待（例如 pod 尚未就绪），稍后重新排队
+type initStepFunc func(ctx context.Context, tang *appsv1.YellowTang) (bool, error)
+
 // 初始化: 一主多从
 // 创建主库和从库 service
 // 创建主库和从库 configmap
+// 创建主库和从库的 pvc
 // 创建主库和从库的 pod
 // 创建主从关系
-func (r *YellowTangReconciler) init(ctx context.Context, tang *appsv1.YellowTang) error {
+//
+// 每一步完成后都会把下一步写入 status.initStep，控制器中途崩溃重启后从记录的步骤继续，
+// 每一步本身也是幂等的，已经存在的资源不会重复创建
+func (r *YellowTangReconciler) init(ctx context.Context, tang *appsv1.YellowTang) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
-	logger.Info("开始初始化集群...")
+	logger.Info("开始初始化集群...", "step", tang.Status.InitStep)
 
 	// 校验副本数量
 	replicas := tang.Spec.Replicas
 	if replicas < 1 {
-		return fmt.Errorf("invalid replica count: %d", replicas)
+		return ctrl.Result{}, fmt.Errorf("invalid replica count: %d", replicas)
+	}
+
+	steps := []struct {
+		step appsv1.InitStep
+		run  initStepFunc
+	}{
+		{appsv1.InitStepServices, r.initServices},
+		{appsv1.InitStepConfigs, r.initConfigs},
+		{appsv1.InitStepStorage, r.initStorage},
+		{appsv1.InitStepPods, r.initPods},
+		{appsv1.InitStepReplication, r.initReplication},
+	}
+
+	if tang.Status.InitStep == "" {
+		tang.Status.InitStep = appsv1.InitStepServices
+	}
+
+	for i, s := range steps {
+		if s.step != tang.Status.InitStep {
+			continue
+		}
+
+		done, err := s.run(ctx, tang)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("init step %s: %v", s.step, err)
+		}
+		if !done {
+			logger.Info("初始化步骤尚未完成，稍后重试", "step", s.step)
+			return ctrl.Result{RequeueAfter: initRequeueInterval}, nil
+		}
+
+		// 记录下一步
+		next := appsv1.InitStepDone
+		if i+1 < len(steps) {
+			next = steps[i+1].step
+		}
+		tang.Status.InitStep = next
+		if err := r.updateStatus(ctx, tang); err != nil {
+			return ctrl.Result{}, err
+		}
+		logger.Info("初始化步骤完成", "step", s.step, "next", next)
 	}
 
-	// 创建 svc
-	if _, err := r.getorCreateService(tang.Spec.MasterServiceName, "master", ctx, tang); err != nil {
+	if tang.Status.InitStep != appsv1.InitStepDone {
+		return ctrl.Result{}, fmt.Errorf("unknown init step %q", tang.Status.InitStep)
+	}
+	return ctrl.Result{}, nil
+}
+
+// 创建 svc
+func (r *YellowTangReconciler) initServices(ctx context.Context, tang *appsv1.YellowTang) (bool, error) {
+	logger := log.FromContext(ctx)
+	if _, err := r.getorCreateHeadlessService(ctx, tang); err != nil {
+		logger.Error(err, "创建无头 svc 失败")
+		return false, fmt.Errorf("failed to create headless service: %v", err)
+	}
+	if _, err := r.getorCreateService(masterServiceName(tang), "master", ctx, tang); err != nil {
 		logger.Error(err, "创建 master svc 失败")
-		return fmt.Errorf("failed to create master service: %v", err)
+		return false, fmt.Errorf("failed to create master service: %v", err)
 	}
-	if _, err := r.getorCreateService(tang.Spec.SlaveServiceName, "slave", ctx, tang); err != nil {
+	if _, err := r.getorCreateService(slaveServiceName(tang), "slave", ctx, tang); err != nil {
 		logger.Error(err, "创建 slave svc 失败")
-		return fmt.Errorf("failed to create slave service: %v", err)
+		return false, fmt.Errorf("failed to create slave service: %v", err)
 	}
+	return true, nil
+}
 
-	// 创建 cm
-	for i := int32(1); i <= replicas; i++ {
-		serverId := int(i)
-		configMapName := fmt.Sprintf("mysql-%02d", i)
-		if _, err := r.getorCreatConfigMap(configMapName, serverId, ctx, tang); err != nil {
-			return fmt.Errorf("failed to create configmap %s: %v", configMapName, err)
+// 创建 cm
+func (r *YellowTangReconciler) initConfigs(ctx context.Context, tang *appsv1.YellowTang) (bool, error) {
+	for i := 0; i < int(tang.Spec.Replicas); i++ {
+		configMapName := mysqlPodName(tang, i)
+		if _, err := r.getorCreatConfigMap(configMapName, serverID(i), ctx, tang); err != nil {
+			return false, fmt.Errorf("failed to create configmap %s: %v", configMapName, err)
 		}
 	}
+	return true, nil
+}
 
-	// 创建 pvc
-	for i := int32(1); i <= replicas; i++ {
-		pvcName := fmt.Sprintf("mysql-%02d", i)
+// 创建 pvc
+func (r *YellowTangReconciler) initStorage(ctx context.Context, tang *appsv1.YellowTang) (bool, error) {
+	for i := 0; i < int(tang.Spec.Replicas); i++ {
+		pvcName := mysqlPodName(tang, i)
 		if _, err := r.getorCreatePVC(pvcName, ctx, tang); err != nil {
-			return fmt.Errorf("failed to create pvc %s: %v", pvcName, err)
+			return false, fmt.Errorf("failed to create pvc %s: %v", pvcName, err)
 		}
 	}
+	return true, nil
+}
 
-	// 创建 Pod
-	for i := int32(1); i <= replicas; i++ {
-		podName := fmt.Sprintf("mysql-%02d", i)
-		pvcName := fmt.Sprintf("mysql-%02d", i)
-		configmapName := fmt.Sprintf("mysql-%02d", i)
+// 创建 Pod，已经存在的 pod 不再重复创建
+// 所有 pod 就绪后该步骤才算完成，否则会提前制作主从，会因为pod尚未就绪而导致大量失败
+// 这里不阻塞等待，pod 就绪的 watch 事件或者重新排队会再次进入该步骤
+func (r *YellowTangReconciler) initPods(ctx context.Context, tang *appsv1.YellowTang) (bool, error) {
+	pods := []corev1.Pod{}
+	for i := 0; i < int(tang.Spec.Replicas); i++ {
+		podName := mysqlPodName(tang, i)
+		pvcName := podName
+		configmapName := podName
 
-		if _, err := r.createPod(podName, pvcName, configmapName, ctx, tang); err != nil {
-			return fmt.Errorf("failed to create pod %s: %v", podName, err)
+		pod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: podName}, ctx, tang)
+		if errors.IsNotFound(err) {
+			pod, err = r.createPod(podName, pvcName, configmapName, nil, ctx, tang)
+			if err != nil {
+				return false, fmt.Errorf("failed to create pod %s: %v", podName, err)
+			}
+		} else if err != nil {
+			return false, err
+		} else if err := checkOwnership(pod, tang); err != nil {
+			return false, err
 		}
+		pods = append(pods, *pod)
 	}
-	// 6、制作主从关系
-	// 初始集群，默认把第一个 pod 当作主库
-	masterPodName := "mysql-01"
+	return markPodsReadiness(pods, tang), nil
+}
+
+// 制作主从关系
+// 初始集群，默认把第一个 pod 当作主库；设置了 spec.externalSource 时主库先从外部 MySQL 复制
+func (r *YellowTangReconciler) initReplication(ctx context.Context, tang *appsv1.YellowTang) (bool, error) {
+	logger := log.FromContext(ctx)
+
+	if topology(tang) == appsv1.TopologyGroupReplication {
+		return r.initGroupReplication(ctx, tang)
+	}
+
+	masterPodName := mysqlPodName(tang, 0)
 	slavePodNames := []string{}
-	for i := int32(2); i <= replicas; i++ {
-		slavePodNames = append(slavePodNames, fmt.Sprintf("mysql-%02d", i))
+	for i := 1; i < int(tang.Spec.Replicas); i++ {
+		slavePodNames = append(slavePodNames, mysqlPodName(tang, i))
 	}
 	logger.Info("init函数", "masterPodName", masterPodName, "slavePodNames", slavePodNames)
 
 	if err := r.setupMasterSlaveReplication(ctx, masterPodName, slavePodNames, tang); err != nil {
 		logger.Info("制作主从失败", "err", err)
-
-		return fmt.Errorf("failed to setup master-slave: %v", err)
+		return false, fmt.Errorf("failed to setup master-slave: %v", err)
 	}
 
-	return nil
+	tang.Status.MasterPod = masterPodName
+	if externalReplicationWanted(tang) {
+		setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionTrue, "MasterReady",
+			fmt.Sprintf("%s bootstrapped as master replicating from external source %s", masterPodName, tang.Spec.ExternalSource.Host))
+		return true, nil
+	}
+	setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionTrue, "MasterReady", fmt.Sprintf("%s bootstrapped as master", masterPodName))
+	return true, nil
 }
@@ -3,10 +3,7 @@ package controller
 import (
 	"context"
 	"fmt"
-	"os"
-	"regexp"
 	"strings"
-	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -14,19 +11,20 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/remotecommand"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/mysql"
 )
 
-func TrimLinesRegex(text string) string {
-	// 去除每行开头的空格和制表符
-	re := regexp.MustCompile(`(?m)^[ \t]+|[ \t]+$`)
-	return re.ReplaceAllString(text, "")
+// 同名对象不是该集群创建的（属于同一个 namespace 中的其他集群或者是用户创建的）时不能接管
+func checkOwnership(obj metav1.Object, tang *appsv1.YellowTang) error {
+	if metav1.IsControlledBy(obj, tang) {
+		return nil
+	}
+	return fmt.Errorf("%s already exists and is not owned by %s", obj.GetName(), tang.Name)
 }
 
 func isPodHealthy(pod corev1.Pod) bool {
@@ -48,7 +46,7 @@ func (r *YellowTangReconciler) getorCreateService(name string, role string, ctx
 	serviceKey := client.ObjectKey{Namespace: tang.Namespace, Name: name}
 	service, err := r.getService(serviceKey, ctx, tang)
 	if err == nil {
-		return service, nil
+		return service, checkOwnership(service, tang)
 	}
 
 	logger.Info("没有找到 svc", "svcName", name)
@@ -77,22 +75,14 @@ func (r *YellowTangReconciler) createService(name string, role string, ctx conte
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: tang.Namespace,
-			Labels: map[string]string{
-				"tang": "true",
-				"app":  "mysql",
-				"role": role,
-			},
+			Labels:    roleLabels(tang, role),
 			OwnerReferences: []metav1.OwnerReference{
 				ownerRef,
 			},
 		},
 		Spec: corev1.ServiceSpec{
-			Type: corev1.ServiceTypeClusterIP,
-			Selector: map[string]string{
-				"tang": "true",
-				"app":  "mysql",
-				"role": role,
-			},
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: roleLabels(tang, role),
 			Ports: []corev1.ServicePort{
 				{
 					Port:       3306,
@@ -110,6 +100,55 @@ func (r *YellowTangReconciler) createService(name string, role string, ctx conte
 
 }
 
+// 无头 service，给每个 pod 提供稳定的 DNS
+// 没有就绪的 pod 也发布 DNS 记录，初始化和克隆时就能通过名字访问
+func (r *YellowTangReconciler) getorCreateHeadlessService(ctx context.Context, tang *appsv1.YellowTang) (*corev1.Service, error) {
+	name := headlessServiceName(tang)
+	service, err := r.getService(client.ObjectKey{Namespace: tang.Namespace, Name: name}, ctx, tang)
+	if err == nil {
+		return service, checkOwnership(service, tang)
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	ownerRef := metav1.OwnerReference{
+		APIVersion: MysqlClusterAPIVersion,
+		Kind:       MysqlClusterKind,
+		Name:       tang.Name,
+		UID:        tang.UID,
+		Controller: func(b bool) *bool { return &b }(true),
+	}
+	service = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: tang.Namespace,
+			Labels:    clusterLabels(tang),
+			OwnerReferences: []metav1.OwnerReference{
+				ownerRef,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			PublishNotReadyAddresses: true,
+			Selector:                 clusterLabels(tang),
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "mysql",
+					Port:       3306,
+					TargetPort: intstr.FromInt(3306),
+					Protocol:   corev1.ProtocolTCP,
+				},
+			},
+		},
+	}
+	if err := r.Create(ctx, service); err != nil {
+		return nil, err
+	}
+	log.FromContext(ctx).Info("无头 svc 创建成功", "svcName", name)
+	return service, nil
+}
+
 func (r *YellowTangReconciler) getConfigMap(cmKey client.ObjectKey, ctx context.Context, tang *appsv1.YellowTang) (*corev1.ConfigMap, error) {
 	cm := corev1.ConfigMap{}
 	if err := r.Get(ctx, cmKey, &cm); err != nil {
@@ -123,6 +162,21 @@ func (r *YellowTangReconciler) getorCreatConfigMap(name string, serverId int, ct
 	cmKey := client.ObjectKey{Namespace: tang.Namespace, Name: name}
 	cm, err := r.getConfigMap(cmKey, ctx, tang)
 	if err == nil {
+		if err := checkOwnership(cm, tang); err != nil {
+			return nil, err
+		}
+		// spec.mysqlConfig 变化后更新已有的 ConfigMap，之后新建或重启的 pod 使用新配置
+		data := renderPodMySQLConfig(name, serverId, tang)
+		if cm.Data["my.cnf"] == data {
+			return cm, nil
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data["my.cnf"] = data
+		if err := r.Update(ctx, cm); err != nil {
+			return nil, err
+		}
 		return cm, nil
 	}
 
@@ -145,30 +199,17 @@ func (r *YellowTangReconciler) createConfigMap(name string, serverId int, ctx co
 		Controller: func(b bool) *bool { return &b }(true),
 	}
 
-	configMapData := fmt.Sprintf(`[mysqld]
-        server-id=%d
-        binlog_format=row
-        log-bin=mysql-bin
-        skip-name-resolve
-        gtid-mode=on
-        enforce-gtid-consistency=true
-        log-slave-updates=1
-        relay_log_purge=0
-        # other configurations`, serverId)
 	cm := corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: tang.Namespace,
-			Labels: map[string]string{
-				"tang": "true",
-				"app":  "mysql",
-			},
+			Labels:    clusterLabels(tang),
 			OwnerReferences: []metav1.OwnerReference{
 				ownerRef,
 			},
 		},
 		Data: map[string]string{
-			"my.cnf": TrimLinesRegex(configMapData),
+			"my.cnf": renderPodMySQLConfig(name, serverId, tang),
 		},
 	}
 	if err := r.Create(ctx, &cm); err != nil {
@@ -192,7 +233,7 @@ func (r *YellowTangReconciler) getorCreatePVC(name string, ctx context.Context,
 	pvcKey := client.ObjectKey{Namespace: tang.Namespace, Name: name}
 	pvc, err := r.getPVC(pvcKey, ctx, tang)
 	if err == nil {
-		return pvc, nil
+		return pvc, checkOwnership(pvc, tang)
 	}
 
 	if errors.IsNotFound(err) {
@@ -218,10 +259,7 @@ func (r *YellowTangReconciler) createPVC(name string, ctx context.Context, tang
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: tang.Namespace,
-			Labels: map[string]string{
-				"tang": "true",
-				"app":  "mysql",
-			},
+			Labels:    clusterLabels(tang),
 			OwnerReferences: []metav1.OwnerReference{
 				ownerRef,
 			},
@@ -246,7 +284,38 @@ func (r *YellowTangReconciler) createPVC(name string, ctx context.Context, tang
 
 }
 
-func (r *YellowTangReconciler) createPod(podName, pvcName, configMapName string, ctx context.Context, tang *appsv1.YellowTang) (*corev1.Pod, error) {
+func (r *YellowTangReconciler) getPod(podKey client.ObjectKey, ctx context.Context, tang *appsv1.YellowTang) (*corev1.Pod, error) {
+	pod := corev1.Pod{}
+	if err := r.Get(ctx, podKey, &pod); err != nil {
+		return nil, err
+	}
+	return &pod, nil
+
+}
+
+// source 不为空时通过 clone init 容器从 donor 拷贝数据
+func (r *YellowTangReconciler) createPod(podName, pvcName, configMapName string, source *cloneSource, ctx context.Context, tang *appsv1.YellowTang) (*corev1.Pod, error) {
+	// 确保账号密码 Secret 存在，pod 通过 secretKeyRef 读取 root 密码
+	if _, err := r.getorCreateCredentialsSecret(ctx, tang); err != nil {
+		return nil, fmt.Errorf("failed to get credentials secret: %v", err)
+	}
+
+	pod := buildPod(podName, pvcName, configMapName, source, tang)
+	pod.Annotations[AnnotationPodTemplateHash] = podTemplateHash(tang)
+	if err := r.Create(ctx, &pod); err != nil {
+		r.Log.Error(err, "Failed to create Pod", "Pod.Name", podName)
+		return nil, err
+	}
+	r.Log.Info("POD created successfully", "POD.Name", podName)
+
+	// 不在这里等待 pod 就绪，由调用方重新排队或者由 pod 的 watch 事件触发下一次调谐
+	// 就绪检查见 checkPodReady
+	return &pod, nil
+
+}
+
+// 按当前 spec 生成 pod，source 不为空时加上克隆数据的 init 容器
+func buildPod(podName, pvcName, configMapName string, source *cloneSource, tang *appsv1.YellowTang) corev1.Pod {
 	// 定义 OwnerReference
 	ownerRef := metav1.OwnerReference{
 		APIVersion: MysqlClusterAPIVersion,
@@ -272,23 +341,34 @@ func (r *YellowTangReconciler) createPod(podName, pvcName, configMapName string,
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      podName,
 			Namespace: tang.Namespace,
-			Labels: map[string]string{
-				"tang": "true",
-				"app":  "mysql",
+			Labels:    clusterLabels(tang),
+			// ConfigMap 已经按当前配置生成，新 pod 启动后配置即生效
+			Annotations: map[string]string{
+				AnnotationMySQLConfig: mysqlConfigAnnotation(mysqlConfigSettings(tang)),
 			},
 			OwnerReferences: []metav1.OwnerReference{
 				ownerRef,
 			},
 		},
 		Spec: corev1.PodSpec{
+			// 通过无头 service 解析为 <pod>.<cr>-mysql.<namespace>.svc
+			Hostname:  podName,
+			Subdomain: headlessServiceName(tang),
 			Containers: []corev1.Container{
 				{
 					Name:  "mysql",
 					Image: tang.Spec.Image,
 					Env: []corev1.EnvVar{
 						{
-							Name:  "MYSQL_ROOT_PASSWORD",
-							Value: MySQLPassword, // 设置 MySQL root 用户的密码
+							Name: "MYSQL_ROOT_PASSWORD", // 设置 MySQL root 用户的密码
+							ValueFrom: &corev1.EnvVarSource{
+								SecretKeyRef: &corev1.SecretKeySelector{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: credentialsSecretName(tang),
+									},
+									Key: SecretKeyRootPassword,
+								},
+							},
 						},
 					},
 					Ports: []corev1.ContainerPort{
@@ -335,45 +415,8 @@ func (r *YellowTangReconciler) createPod(podName, pvcName, configMapName string,
 			},
 		},
 	}
-	if err := r.Create(ctx, &pod); err != nil {
-		r.Log.Error(err, "Failed to create Pod", "Pod.Name", podName)
-		return nil, err
-	}
-	r.Log.Info("POD created successfully", "POD.Name", podName)
-
-	// 新增：等待pod就绪的功能，否则会提前制作主从，会因为pod尚未就绪而导致大量失败
-	// todo: 增加就绪等待时间和次数
-	podKey := client.ObjectKey{Namespace: tang.Namespace, Name: podName}
-
-	start := time.Now()
-
-	for {
-		// 等待一段时间再检查 Pod 状态
-		time.Sleep(5 * time.Second)
-
-		// 获取 Pod 状态
-		pod := &corev1.Pod{}
-		if err := r.Get(ctx, podKey, pod); err != nil { // 在调用 r.Get 后，pod 变量将包含 Kubernetes 集群中该 Pod 的当前状态。
-			r.Log.Error(err, "Failed to get Pod", "Pod.Name", podName)
-			//return err
-			continue
-		}
-
-		// 检查 Pod 是否健康
-		if isPodHealthy(*pod) {
-			log.Log.Info("pod就绪")
-			r.Log.Info("Pod is healthy", "Pod.Name", podName)
-			break
-		} else {
-			log.Log.Info("pod未就绪")
-			r.Log.Info("Waiting for Pod to become healthy", "Pod.Name", podName)
-		}
-	}
-	elapsed := time.Since(start)
-	fmt.Printf("等待 pod 就绪耗时: %v\n", elapsed)
-
-	return &pod, nil
-
+	addCloneContainers(&pod, source, credentialsSecretName(tang), tang)
+	return pod
 }
 
 // labelPod 为 Pod 打标签
@@ -408,13 +451,58 @@ func (r *YellowTangReconciler) setupMasterSlaveReplication(ctx context.Context,
 		return fmt.Errorf("failed to label master pod %s: %v", masterName, err)
 	}
 
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return err
+	}
+
 	// 为主库创建复制用户，并停止slave线程（如果之前自己是从库，那就应该停掉）
-	masterCommand := fmt.Sprintf(
-		"mysql -uroot -p%s -e \"CREATE USER IF NOT EXISTS 'replica'@'%%' IDENTIFIED BY 'password'; GRANT REPLICATION SLAVE ON *.* TO 'replica'@'%%';STOP slave;\"",
-		MySQLPassword,
-	)
-	if _, err := r.execCommandOnPod(masterPod, masterCommand); err != nil {
-		return fmt.Errorf("failed to execute command on master pod %s: %v", masterName, err)
+	// 从库都开启了 super_read_only，被提升的从库要先关掉只读
+	user := mysql.QuoteString(creds.ReplicationUser)
+	masterStatements := []string{}
+	createUser := true
+	if externalReplicationWanted(tang) {
+		// 从外部 MySQL 复制时主库保持只读，不能有客户端写入
+		// 已经只读的主库上复制用户在集群初始化时创建过，并且已经同步到了所有从库
+		value, err := mysql.GetGlobalVariable(ctx, r.SQL, rootTarget(masterPod, creds.RootPassword), "super_read_only")
+		if err != nil {
+			return fmt.Errorf("failed to read super_read_only on master pod %s: %v", masterName, err)
+		}
+		createUser = value != "ON"
+	} else {
+		masterStatements = append(masterStatements, "SET GLOBAL super_read_only = OFF", "SET GLOBAL read_only = OFF")
+	}
+	if createUser {
+		masterStatements = append(masterStatements,
+			fmt.Sprintf("CREATE USER IF NOT EXISTS %s@'%%' IDENTIFIED BY %s", user, mysql.QuoteString(creds.ReplicationPassword)),
+			fmt.Sprintf("GRANT REPLICATION SLAVE ON *.* TO %s@'%%'", user),
+		)
+	}
+	if !externalReplicationWanted(tang) {
+		masterStatements = append(masterStatements, "STOP SLAVE")
+	}
+	if len(masterStatements) > 0 {
+		if err := r.execSQL(ctx, masterPod, creds, masterStatements...); err != nil {
+			return fmt.Errorf("failed to execute command on master pod %s: %v", masterName, err)
+		}
+	}
+	// spec.externalSource 还没有请求 promote 时主库从外部 MySQL 复制，并重新开启只读
+	if externalReplicationWanted(tang) {
+		if err := r.startExternalReplication(ctx, masterPod, creds, tang); err != nil {
+			return err
+		}
+	}
+	// 每次提升新主库后重新开启半同步，失败时由 reconcileSemisync 重试
+	if semisyncManaged(tang) {
+		if err := r.configureSemisyncMaster(ctx, masterPod, creds, tang); err != nil {
+			log.Info("主库配置半同步失败", "masterName", masterName, "error", err)
+		}
+	}
+
+	// 级联复制时从库指向中间从库，中间从库不可用时指向上一级
+	sources, err := r.getCascadingSources(ctx, tang)
+	if err != nil {
+		return err
 	}
 
 	// 配置每个从库: 如果从库名数组为空，则
@@ -425,19 +513,29 @@ func (r *YellowTangReconciler) setupMasterSlaveReplication(ctx context.Context,
 			return fmt.Errorf("failed to get slave pod %s: %v", slaveName, err)
 		}
 
+		// 在 START SLAVE 之前开启从库端的半同步，IO 线程连接主库时生效
+		if semisyncManaged(tang) {
+			if _, err := r.configureSemisyncSlave(ctx, slavePod, creds, tang); err != nil {
+				log.Info("从库配置半同步失败", "slaveName", slaveName, "error", err)
+			}
+		}
+
 		// 配置主从复制: 先停slave，再配置、然后再启slave
-		masterServiceName := tang.Spec.MasterServiceName
-		slaveCommand := fmt.Sprintf(
-			"mysql -uroot -p%s -e \"STOP SLAVE;CHANGE MASTER TO MASTER_HOST='%s', MASTER_USER='replica', MASTER_PASSWORD='password', MASTER_AUTO_POSITION=1; START SLAVE;\"",
-			MySQLPassword,
-			masterServiceName,
-		)
-		if _, err := r.execCommandOnPod(slavePod, slaveCommand); err != nil {
+		// 从库开启 super_read_only，防止通过 slave-service 写入产生 errant 事务
+		// 延迟从库设置 MASTER_DELAY，其他从库设置为 0，不再是延迟从库的 pod 恢复正常复制
+		sourceHost := replicationSourceHost(slaveName, masterName, sources, tang)
+		if err := r.execSQL(ctx, slavePod, creds,
+			"STOP SLAVE",
+			fmt.Sprintf("CHANGE MASTER TO MASTER_HOST=%s, MASTER_USER=%s, MASTER_PASSWORD=%s, MASTER_AUTO_POSITION=1, MASTER_DELAY=%d",
+				mysql.QuoteString(sourceHost), user, mysql.QuoteString(creds.ReplicationPassword), replicaDelay(slaveName, tang)),
+			"START SLAVE",
+			"SET GLOBAL super_read_only = ON",
+		); err != nil {
 			return fmt.Errorf("failed to execute command on slave pod %s: %v", slaveName, err)
 		}
 
 		// 打标签
-		if err := r.labelPod(slavePod, "slave", ctx, tang); err != nil {
+		if err := r.labelPod(slavePod, slaveRole(slaveName, tang), ctx, tang); err != nil {
 			return fmt.Errorf("failed to label slave pod %s: %v", slaveName, err)
 		}
 	}
@@ -446,27 +544,15 @@ func (r *YellowTangReconciler) setupMasterSlaveReplication(ctx context.Context,
 }
 
 // kubectl exec 进入pod内执行命令
-func (r *YellowTangReconciler) execCommandOnPod(pod *corev1.Pod, command string) (string, error) {
-	// Load kubeconfig from default location
-	kubeconfig := os.Getenv("KUBECONFIG")
-	if kubeconfig == "" {
-		kubeconfig = "/root/.kube/config" // Fallback to default path
-	}
-	config, err := clientcmd.BuildConfigFromFlags("", KubeConfigPath) // 来自包："k8s.io/client-go/tools/clientcmd"
-	// config, err := rest.InClusterConfig() // 来自包："k8s.io/client-go/rest"
-
-	if err != nil {
-		return "", err
-	}
-
-	// Create a new Kubernetes clientset
-	kubeClient, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return "", err
+func (r *YellowTangReconciler) execCommandOnPod(ctx context.Context, pod *corev1.Pod, command string) (string, error) {
+	// 使用 SetupWithManager 时从 manager 拿到的 rest.Config 和 clientset
+	// 不管 manager 是在集群内以 Deployment 运行还是在本地通过 kubeconfig 运行都可以
+	if r.restConfig == nil || r.kubeClient == nil {
+		return "", fmt.Errorf("pod exec client is not configured, SetupWithManager must be called first")
 	}
 
 	// Create REST client for pod exec
-	restClient := kubeClient.CoreV1().RESTClient()
+	restClient := r.kubeClient.CoreV1().RESTClient()
 	req := restClient.
 		Post().
 		Resource("pods").
@@ -483,19 +569,19 @@ func (r *YellowTangReconciler) execCommandOnPod(pod *corev1.Pod, command string)
 		Param("command", command)
 
 	// Create an executor
-	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	executor, err := remotecommand.NewSPDYExecutor(r.restConfig, "POST", req.URL())
 	if err != nil {
 		return "", err
 	}
 
-	// Execute the command
-	var output strings.Builder
-	err = executor.Stream(remotecommand.StreamOptions{
+	// Execute the command，ctx 取消或超时时中断
+	var output, stderr strings.Builder
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
 		Stdout: &output,
-		Stderr: os.Stderr,
+		Stderr: &stderr,
 	})
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
 	}
 
 	return output.String(), nil
@@ -509,7 +595,7 @@ func (r *YellowTangReconciler) getPodByLabels(selectLabels map[string]string, ct
 	// 创建 ListOptions，根据需要筛选 Pod（使用标签选择器或其他筛选条件）
 	listOptions := &client.ListOptions{
 		Namespace:     tang.Namespace,
-		LabelSelector: labels.SelectorFromSet(selectLabels),
+		LabelSelector: labels.SelectorFromSet(scopeLabels(selectLabels, tang)),
 	}
 
 	// 获取 Pod 列表
@@ -526,10 +612,32 @@ func (r *YellowTangReconciler) getPodByLabels(selectLabels map[string]string, ct
 		}
 	}
 
-	// 过滤掉正在删除的 Pod
+	// 过滤掉正在删除的 Pod 和不属于该集群的 pod
 	var activePods []corev1.Pod
 	for _, pod := range readyPodList {
-		if pod.DeletionTimestamp == nil {
+		if pod.DeletionTimestamp == nil && metav1.IsControlledBy(&pod, tang) {
+			activePods = append(activePods, pod)
+		}
+	}
+	return activePods, nil
+}
+
+// 获取所有未处于删除中的 pod，不管是否就绪
+// 用于判断 pod 是否已经创建，避免对尚未就绪的 pod 重复创建
+func (r *YellowTangReconciler) getAllPodByLabels(selectLabels map[string]string, ctx context.Context, tang *appsv1.YellowTang) ([]corev1.Pod, error) {
+	podList := corev1.PodList{}
+	listOptions := &client.ListOptions{
+		Namespace:     tang.Namespace,
+		LabelSelector: labels.SelectorFromSet(scopeLabels(selectLabels, tang)),
+	}
+	if err := r.List(ctx, &podList, listOptions); err != nil {
+		return nil, err
+	}
+
+	// 同一个 namespace 中可能有多个集群，只保留 ownerReference 指向该集群的 pod
+	var activePods []corev1.Pod
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp == nil && metav1.IsControlledBy(&pod, tang) {
 			activePods = append(activePods, pod)
 		}
 	}
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+// 旧版本给 pod、PVC 和 ConfigMap 打的标签
+var legacyLabels = map[string]string{"tang": "true", "app": "mysql"}
+
+// 接管旧版本创建的集群，只在带有 annotation "initialized"、还没有 status.initStep 时调用
+// 1. 给属于该集群的 mysql-NN pod、PVC 和 ConfigMap 加上集群标签，之后按集群标签能选中它们
+// 2. 在 YellowTang 上打上 AnnotationLegacyPodNames，之后新建的对象也沿用 mysql-NN 的名字
+// annotation 最后打上，中途失败时下次调谐重新执行
+func (r *YellowTangReconciler) adoptLegacyCluster(ctx context.Context, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	objects := []client.Object{}
+	podList := corev1.PodList{}
+	if err := r.List(ctx, &podList, client.InNamespace(tang.Namespace), client.MatchingLabels(legacyLabels)); err != nil {
+		return fmt.Errorf("failed to list legacy pods: %v", err)
+	}
+	for i := range podList.Items {
+		objects = append(objects, &podList.Items[i])
+	}
+	pvcList := corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, &pvcList, client.InNamespace(tang.Namespace), client.MatchingLabels(legacyLabels)); err != nil {
+		return fmt.Errorf("failed to list legacy pvcs: %v", err)
+	}
+	for i := range pvcList.Items {
+		objects = append(objects, &pvcList.Items[i])
+	}
+	cmList := corev1.ConfigMapList{}
+	if err := r.List(ctx, &cmList, client.InNamespace(tang.Namespace), client.MatchingLabels(legacyLabels)); err != nil {
+		return fmt.Errorf("failed to list legacy configmaps: %v", err)
+	}
+	for i := range cmList.Items {
+		objects = append(objects, &cmList.Items[i])
+	}
+
+	for _, object := range objects {
+		if !metav1.IsControlledBy(object, tang) || object.GetLabels()[LabelCluster] == tang.Name {
+			continue
+		}
+		patch := client.MergeFrom(object.DeepCopyObject().(client.Object))
+		labels := object.GetLabels()
+		labels[LabelCluster] = tang.Name
+		object.SetLabels(labels)
+		if err := r.Patch(ctx, object, patch); err != nil {
+			return fmt.Errorf("failed to label legacy object %s: %v", object.GetName(), err)
+		}
+		logger.Info("接管旧版本创建的对象", "名字", object.GetName())
+	}
+
+	if legacyPodNames(tang) {
+		return nil
+	}
+	patch := client.MergeFrom(tang.DeepCopy())
+	if tang.Annotations == nil {
+		tang.Annotations = map[string]string{}
+	}
+	tang.Annotations[AnnotationLegacyPodNames] = "true"
+	if err := r.Patch(ctx, tang, patch); err != nil {
+		return fmt.Errorf("failed to mark %s as using legacy pod names: %v", tang.Name, err)
+	}
+	logger.Info("集群沿用旧版本的 pod 名字", "集群", tang.Name)
+	return nil
+}
@@ -0,0 +1,376 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/mysql"
+)
+
+// pod 上已经生效的 my.cnf 配置（不含 server-id），JSON 格式
+const AnnotationMySQLConfig = "yellowtang.kaxonliu.com/mysql-config"
+
+// 由 operator 管理、不能通过 spec.mysqlConfig 修改的配置
+var protectedMySQLConfigKeys = map[string]bool{
+	"server-id":                true,
+	"gtid-mode":                true,
+	"enforce-gtid-consistency": true,
+	"log-bin":                  true,
+	"log-slave-updates":        true,
+	"log-replica-updates":      true,
+	"read-only":                true,
+	"super-read-only":          true,
+	// 由 spec.replication 管理
+	"rpl-semi-sync-master-enabled":              true,
+	"rpl-semi-sync-slave-enabled":               true,
+	"rpl-semi-sync-master-wait-for-slave-count": true,
+	"rpl-semi-sync-master-timeout":              true,
+	// 由 spec.topology 管理
+	"report-host":                                        true,
+	"group-replication-group-name":                       true,
+	"group-replication-local-address":                    true,
+	"group-replication-group-seeds":                      true,
+	"group-replication-start-on-boot":                    true,
+	"group-replication-bootstrap-group":                  true,
+	"group-replication-single-primary-mode":              true,
+	"group-replication-enforce-update-everywhere-checks": true,
+}
+
+// 配置名只能包含字母、数字、- 和 _，会直接拼到 SET 语句中
+var mysqlConfigKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// 带单位的大小，例如 128M
+var mysqlSizePattern = regexp.MustCompile(`^(\d+)([KMGkmg])$`)
+
+// 配置名统一成小写、用 - 分隔，MySQL 中 - 和 _ 等价
+func normalizeMySQLConfigKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "_", "-")
+}
+
+// operator 管理的默认配置，server-id 按 pod 编号单独生成
+func defaultMySQLConfig(tang *appsv1.YellowTang) map[string]string {
+	config := map[string]string{
+		"binlog-format":            "row",
+		"log-bin":                  "mysql-bin",
+		"skip-name-resolve":        "",
+		"gtid-mode":                "on",
+		"enforce-gtid-consistency": "true",
+		"log-slave-updates":        "1",
+		"relay-log-purge":          "0",
+	}
+	// Clone 方式下每个实例都可能作为 donor，启动时加载 CLONE 插件
+	plugins := []string{}
+	if cloneMethod(tang) == appsv1.CloneMethodClone {
+		plugins = append(plugins, "mysql_clone.so")
+	}
+	if topology(tang) == appsv1.TopologyGroupReplication {
+		plugins = append(plugins, "group_replication.so")
+		for key, value := range groupReplicationConfig(tang) {
+			config[key] = value
+		}
+	}
+	if len(plugins) > 0 {
+		config["plugin-load-add"] = strings.Join(plugins, ";")
+	}
+	return config
+}
+
+// spec.mysqlConfig 中不允许设置的配置
+func invalidMySQLConfigKeys(tang *appsv1.YellowTang) []string {
+	invalid := []string{}
+	for key := range tang.Spec.MySQLConfig {
+		normalized := normalizeMySQLConfigKey(key)
+		if protectedMySQLConfigKeys[normalized] || !mysqlConfigKeyPattern.MatchString(normalized) {
+			invalid = append(invalid, key)
+		}
+	}
+	sort.Strings(invalid)
+	return invalid
+}
+
+// 默认配置和 spec.mysqlConfig 合并后的结果，不允许设置的配置被忽略
+func mysqlConfigSettings(tang *appsv1.YellowTang) map[string]string {
+	settings := defaultMySQLConfig(tang)
+	for key, value := range tang.Spec.MySQLConfig {
+		key = normalizeMySQLConfigKey(key)
+		if protectedMySQLConfigKeys[key] || !mysqlConfigKeyPattern.MatchString(key) {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		// operator 需要的插件不能被覆盖，用户的插件加在后面
+		if key == "plugin-load-add" {
+			if value = mergeMySQLPlugins(settings[key], value); value == "" {
+				continue
+			}
+		}
+		settings[key] = value
+	}
+	return settings
+}
+
+// 合并 ; 分隔的插件列表，去掉重复的插件
+func mergeMySQLPlugins(lists ...string) string {
+	plugins := []string{}
+	seen := map[string]bool{}
+	for _, list := range lists {
+		for _, plugin := range strings.Split(list, ";") {
+			plugin = strings.TrimSpace(plugin)
+			if plugin == "" || seen[plugin] {
+				continue
+			}
+			seen[plugin] = true
+			plugins = append(plugins, plugin)
+		}
+	}
+	return strings.Join(plugins, ";")
+}
+
+// 生成 my.cnf
+func renderMySQLConfig(serverId int, settings map[string]string) string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("[mysqld]\n")
+	fmt.Fprintf(&b, "server-id=%d\n", serverId)
+	for _, key := range keys {
+		if settings[key] == "" {
+			fmt.Fprintf(&b, "%s\n", key)
+		} else {
+			fmt.Fprintf(&b, "%s=%s\n", key, settings[key])
+		}
+	}
+	return b.String()
+}
+
+// 生成某个 pod 的 my.cnf，组复制时加上按 pod 区分的地址
+func renderPodMySQLConfig(podName string, serverId int, tang *appsv1.YellowTang) string {
+	settings := mysqlConfigSettings(tang)
+	if topology(tang) == appsv1.TopologyGroupReplication {
+		for key, value := range groupReplicationPodConfig(podName, tang) {
+			settings[key] = value
+		}
+	}
+	return renderMySQLConfig(serverId, settings)
+}
+
+// pod 上已经生效的配置
+// 旧版本创建的 pod 没有 annotation，使用的是写死的默认配置
+func podMySQLConfig(pod *corev1.Pod, tang *appsv1.YellowTang) map[string]string {
+	if value, ok := pod.Annotations[AnnotationMySQLConfig]; ok {
+		settings := map[string]string{}
+		if err := json.Unmarshal([]byte(value), &settings); err == nil {
+			return settings
+		}
+	}
+	return defaultMySQLConfig(tang)
+}
+
+// 序列化后写到 pod 的 annotation 中
+func mysqlConfigAnnotation(settings map[string]string) string {
+	data, _ := json.Marshal(settings)
+	return string(data)
+}
+
+// 一项配置的变化，Removed 表示恢复成 MySQL 的默认值
+type mysqlConfigChange struct {
+	Key     string
+	Value   string
+	Removed bool
+}
+
+// 比较 pod 上已经生效的配置和期望的配置
+func diffMySQLConfig(applied, desired map[string]string) []mysqlConfigChange {
+	changes := []mysqlConfigChange{}
+	for key, value := range desired {
+		if current, ok := applied[key]; !ok || current != value {
+			changes = append(changes, mysqlConfigChange{Key: key, Value: value})
+		}
+	}
+	for key := range applied {
+		if _, ok := desired[key]; !ok {
+			changes = append(changes, mysqlConfigChange{Key: key, Removed: true})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// 配置值转成 sql 中的值，SET 语句不支持 128M 这种带单位的写法
+func sqlConfigValue(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return value
+	}
+	if matches := mysqlSizePattern.FindStringSubmatch(value); len(matches) == 3 {
+		size, _ := strconv.ParseInt(matches[1], 10, 64)
+		switch strings.ToUpper(matches[2]) {
+		case "K":
+			size <<= 10
+		case "M":
+			size <<= 20
+		case "G":
+			size <<= 30
+		}
+		return strconv.FormatInt(size, 10)
+	}
+	return mysql.QuoteString(value)
+}
+
+// 只能通过重启生效的配置：只读变量，以及不是系统变量的启动选项
+func isStaticConfigError(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "read only variable") ||
+		strings.Contains(message, "Unknown system variable") ||
+		strings.Contains(message, "non persistent")
+}
+
+// 在 pod 上在线修改配置，返回需要重启才能生效的配置
+// MySQL 8.0 使用 SET PERSIST，重启后不依赖 ConfigMap 是否已经挂载到新内容
+func (r *YellowTangReconciler) applyMySQLConfigLive(ctx context.Context, pod *corev1.Pod, changes []mysqlConfigChange, creds *mysqlCredentials) ([]string, error) {
+	target := rootTarget(pod, creds.RootPassword)
+	version, err := mysql.GetGlobalVariable(ctx, r.SQL, target, "version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version of %s: %v", pod.Name, err)
+	}
+	persist := !strings.HasPrefix(version, "5.")
+
+	static := []string{}
+	for _, change := range changes {
+		name := strings.ReplaceAll(change.Key, "-", "_")
+		statements := []string{}
+		switch {
+		case change.Removed && persist:
+			statements = append(statements, fmt.Sprintf("RESET PERSIST IF EXISTS %s", name), fmt.Sprintf("SET GLOBAL %s = DEFAULT", name))
+		case change.Removed:
+			statements = append(statements, fmt.Sprintf("SET GLOBAL %s = DEFAULT", name))
+		case change.Value == "":
+			// 不带值的启动选项，例如 skip-name-resolve
+			static = append(static, change.Key)
+			continue
+		case persist:
+			statements = append(statements, fmt.Sprintf("SET PERSIST %s = %s", name, sqlConfigValue(change.Value)))
+		default:
+			statements = append(statements, fmt.Sprintf("SET GLOBAL %s = %s", name, sqlConfigValue(change.Value)))
+		}
+
+		if err := r.SQL.Exec(ctx, target, statements...); err != nil {
+			if isStaticConfigError(err) {
+				static = append(static, change.Key)
+				continue
+			}
+			return nil, fmt.Errorf("failed to set %s on %s: %v", change.Key, pod.Name, err)
+		}
+	}
+	return static, nil
+}
+
+// 记录 pod 上已经生效的配置
+func (r *YellowTangReconciler) annotateMySQLConfig(ctx context.Context, pod *corev1.Pod, settings map[string]string) error {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[AnnotationMySQLConfig] = mysqlConfigAnnotation(settings)
+	if err := r.Update(ctx, pod); err != nil {
+		return fmt.Errorf("failed to update pod %s: %v", pod.Name, err)
+	}
+	return nil
+}
+
+// 让 spec.mysqlConfig 在所有 pod 上生效
+// 1. 更新每个 pod 的 ConfigMap，之后新建或重启的 pod 直接使用新配置
+// 2. 在就绪的 pod 上在线修改动态变量，只有动态变量变化时不需要重启
+// 3. 返回有静态配置变化、需要滚动重启的 pod
+func (r *YellowTangReconciler) reconcileMySQLConfig(ctx context.Context, tang *appsv1.YellowTang) ([]string, ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if invalid := invalidMySQLConfigKeys(tang); len(invalid) > 0 {
+		message := fmt.Sprintf("%s cannot be set through spec.mysqlConfig", strings.Join(invalid, ","))
+		if cond := meta.FindStatusCondition(tang.Status.Conditions, appsv1.ConditionConfigApplied); cond == nil || cond.Message != message {
+			r.Recorder.Event(tang, corev1.EventTypeWarning, "InvalidMySQLConfig", message)
+		}
+		setCondition(tang, appsv1.ConditionConfigApplied, metav1.ConditionFalse, "InvalidConfig", message)
+		return nil, ctrl.Result{}, nil
+	}
+	desired := mysqlConfigSettings(tang)
+
+	pods, err := r.getAllPodByLabels(clusterLabels(tang), ctx, tang)
+	if err != nil {
+		return nil, ctrl.Result{}, err
+	}
+	for _, pod := range pods {
+		if podNo, ok := parsePodNo(pod.Name, tang); ok {
+			if _, err := r.getorCreatConfigMap(pod.Name, serverID(podNo), ctx, tang); err != nil {
+				return nil, ctrl.Result{}, fmt.Errorf("failed to update configmap %s: %v", pod.Name, err)
+			}
+		}
+	}
+
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return nil, ctrl.Result{}, err
+	}
+
+	pending := []string{}
+	restart := []string{}
+	for i := range pods {
+		pod := &pods[i]
+		changes := diffMySQLConfig(podMySQLConfig(pod, tang), desired)
+		if len(changes) == 0 {
+			continue
+		}
+		// 还没就绪、被隔离或者正在克隆的 pod 之后再处理
+		if !isPodHealthy(*pod) || isFencedPod(pod, tang) || cloneInProgress(tang, pod.Name) {
+			pending = append(pending, pod.Name)
+			continue
+		}
+
+		static, err := r.applyMySQLConfigLive(ctx, pod, changes, creds)
+		if err != nil {
+			logger.Info("在线修改配置失败", "Pod", pod.Name, "错误", err)
+			pending = append(pending, pod.Name)
+			continue
+		}
+		if len(static) > 0 {
+			logger.Info("配置需要重启才能生效", "Pod", pod.Name, "配置", static)
+			restart = append(restart, pod.Name)
+			continue
+		}
+		if err := r.annotateMySQLConfig(ctx, pod, desired); err != nil {
+			return nil, ctrl.Result{}, err
+		}
+		logger.Info("配置已在线生效", "Pod", pod.Name)
+		r.Recorder.Eventf(tang, corev1.EventTypeNormal, "MySQLConfigApplied", "mysql config applied online on %s", pod.Name)
+	}
+
+	switch {
+	case len(restart) > 0:
+		sort.Strings(restart)
+		setCondition(tang, appsv1.ConditionConfigApplied, metav1.ConditionFalse, "RestartRequired",
+			fmt.Sprintf("restart required on %s", strings.Join(restart, ",")))
+	case len(pending) > 0:
+		setCondition(tang, appsv1.ConditionConfigApplied, metav1.ConditionFalse, "Pending",
+			fmt.Sprintf("config not applied yet on %s", strings.Join(pending, ",")))
+	default:
+		setCondition(tang, appsv1.ConditionConfigApplied, metav1.ConditionTrue, "Applied", "mysql config is applied on all pods")
+	}
+
+	if len(pending) > 0 {
+		return restart, ctrl.Result{RequeueAfter: podReadyRequeueInterval}, nil
+	}
+	return restart, ctrl.Result{}, nil
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+func newConfigTang(config map[string]string) *appsv1.YellowTang {
+	return &appsv1.YellowTang{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec:       appsv1.YellowTangSpec{Replicas: 3, MySQLConfig: config},
+	}
+}
+
+func TestInvalidMySQLConfigKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		want   []string
+	}{
+		{"empty", nil, []string{}},
+		{"valid keys", map[string]string{"max_connections": "500", "innodb-buffer-pool-size": "128M"}, []string{}},
+		{"protected key", map[string]string{"server-id": "9"}, []string{"server-id"}},
+		{"protected key with underscores", map[string]string{"gtid_mode": "off"}, []string{"gtid_mode"}},
+		{"protected key in upper case", map[string]string{"READ_ONLY": "0"}, []string{"READ_ONLY"}},
+		{"semisync managed by spec.replication", map[string]string{"rpl_semi_sync_master_enabled": "1"}, []string{"rpl_semi_sync_master_enabled"}},
+		{"group replication managed by spec.topology", map[string]string{"group_replication_group_seeds": "a:1"}, []string{"group_replication_group_seeds"}},
+		{"plugin-load-add is allowed", map[string]string{"plugin-load-add": "audit_log.so"}, []string{}},
+		{"sql injection", map[string]string{"max_connections = 1; DROP DATABASE mysql": "1"}, []string{"max_connections = 1; DROP DATABASE mysql"}},
+		{"leading dash", map[string]string{"-max-connections": "1"}, []string{"-max-connections"}},
+		{"sorted", map[string]string{"super_read_only": "1", "log-bin": "x", "max_connections": "1"}, []string{"log-bin", "super_read_only"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := invalidMySQLConfigKeys(newConfigTang(tt.config)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("invalidMySQLConfigKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMySQLConfigSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		want   map[string]string
+	}{
+		{"defaults", nil, map[string]string{}},
+		{"user setting", map[string]string{"max_connections": "500"}, map[string]string{"max-connections": "500"}},
+		{"value trimmed", map[string]string{" Max_Connections ": " 500 "}, map[string]string{"max-connections": "500"}},
+		{"override default", map[string]string{"binlog_format": "mixed"}, map[string]string{"binlog-format": "mixed"}},
+		{"protected key ignored", map[string]string{"gtid_mode": "off"}, map[string]string{"gtid-mode": "on"}},
+		{"invalid key ignored", map[string]string{"a b": "1"}, map[string]string{}},
+		{"bare option", map[string]string{"skip-log-bin": ""}, map[string]string{"skip-log-bin": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tang := newConfigTang(tt.config)
+			want := defaultMySQLConfig(tang)
+			for key, value := range tt.want {
+				want[key] = value
+			}
+			if got := mysqlConfigSettings(tang); !reflect.DeepEqual(got, want) {
+				t.Errorf("mysqlConfigSettings() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDefaultMySQLConfigPlugins(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   appsv1.CloneMethod
+		topology appsv1.Topology
+		want     string
+	}{
+		{"no plugins", "", "", ""},
+		{"xtrabackup", appsv1.CloneMethodXtrabackup, "", ""},
+		{"clone", appsv1.CloneMethodClone, "", "mysql_clone.so"},
+		{"group replication", "", appsv1.TopologyGroupReplication, "group_replication.so"},
+		{"clone and group replication", appsv1.CloneMethodClone, appsv1.TopologyGroupReplication, "mysql_clone.so;group_replication.so"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tang := newConfigTang(nil)
+			tang.Spec.Clone.Method = tt.method
+			tang.Spec.Topology = tt.topology
+			if got := defaultMySQLConfig(tang)["plugin-load-add"]; got != tt.want {
+				t.Errorf("plugin-load-add = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMySQLConfigPluginsMerged(t *testing.T) {
+	tests := []struct {
+		name   string
+		method appsv1.CloneMethod
+		value  string
+		want   string
+	}{
+		{"user plugin only", "", "audit_log.so", "audit_log.so"},
+		{"appended to operator plugins", appsv1.CloneMethodClone, "audit_log.so", "mysql_clone.so;audit_log.so"},
+		{"empty", "", "", ""},
+		{"operator plugin not removed", appsv1.CloneMethodClone, "", "mysql_clone.so"},
+		{"duplicates dropped", appsv1.CloneMethodClone, "audit_log.so; mysql_clone.so;audit_log.so", "mysql_clone.so;audit_log.so"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tang := newConfigTang(map[string]string{"plugin_load_add": tt.value})
+			tang.Spec.Clone.Method = tt.method
+			got, ok := mysqlConfigSettings(tang)["plugin-load-add"]
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("plugin-load-add = %q (set %v), want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMySQLConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		serverId int
+		settings map[string]string
+		want     string
+	}{
+		{"no settings", 1, nil, "[mysqld]\nserver-id=1\n"},
+		{"sorted keys", 3, map[string]string{"log-bin": "mysql-bin", "binlog-format": "row"}, "[mysqld]\nserver-id=3\nbinlog-format=row\nlog-bin=mysql-bin\n"},
+		{"bare option", 2, map[string]string{"skip-name-resolve": "", "gtid-mode": "on"}, "[mysqld]\nserver-id=2\ngtid-mode=on\nskip-name-resolve\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMySQLConfig(tt.serverId, tt.settings); got != tt.want {
+				t.Errorf("renderMySQLConfig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffMySQLConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		applied map[string]string
+		desired map[string]string
+		want    []mysqlConfigChange
+	}{
+		{"unchanged", map[string]string{"a": "1"}, map[string]string{"a": "1"}, []mysqlConfigChange{}},
+		{"added", map[string]string{}, map[string]string{"a": "1"}, []mysqlConfigChange{{Key: "a", Value: "1"}}},
+		{"changed", map[string]string{"a": "1"}, map[string]string{"a": "2"}, []mysqlConfigChange{{Key: "a", Value: "2"}}},
+		{"removed", map[string]string{"a": "1"}, map[string]string{}, []mysqlConfigChange{{Key: "a", Removed: true}}},
+		{"changed to bare option", map[string]string{"a": "1"}, map[string]string{"a": ""}, []mysqlConfigChange{{Key: "a", Value: ""}}},
+		{
+			"sorted by key",
+			map[string]string{"c": "1", "b": "1"},
+			map[string]string{"b": "2", "a": "1"},
+			[]mysqlConfigChange{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}, {Key: "c", Removed: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffMySQLConfig(tt.applied, tt.desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffMySQLConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLConfigValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"500", "500"},
+		{"-1", "-1"},
+		{"0", "0"},
+		{"16K", "16384"},
+		{"128M", "134217728"},
+		{"128m", "134217728"},
+		{"2G", "2147483648"},
+		{"ON", "'ON'"},
+		{"1.5G", "'1.5G'"},
+		{"128MB", "'128MB'"},
+		{"STRICT_TRANS_TABLES,NO_ZERO_DATE", "'STRICT_TRANS_TABLES,NO_ZERO_DATE'"},
+		{"it's", `'it\'s'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := sqlConfigValue(tt.in); got != tt.want {
+				t.Errorf("sqlConfigValue(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+// 集群名字标签，同一个 namespace 中不同 YellowTang 的对象通过它区分
+const LabelCluster = "yellowtang.kaxonliu.com/cluster"
+
+// 旧版本创建的集群：pod、PVC 和 ConfigMap 的名字为 mysql-NN，NN 从 01 开始
+// 升级后第一次调谐时由 adoptLegacyCluster 打上，之后一直沿用旧名字，已有的数据不会被丢下
+const AnnotationLegacyPodNames = "yellowtang.kaxonliu.com/legacy-pod-names"
+
+// 是否沿用旧版本的名字
+func legacyPodNames(tang *appsv1.YellowTang) bool {
+	return tang.Annotations[AnnotationLegacyPodNames] == "true"
+}
+
+// 集群中所有 mysql 对象的标签，也是选择集群 pod 的标签
+// 旧版本的 master/slave service 按 tang=true 选择 pod，沿用旧名字的集群继续带上它
+func clusterLabels(tang *appsv1.YellowTang) map[string]string {
+	labels := map[string]string{
+		"app":        "mysql",
+		LabelCluster: tang.Name,
+	}
+	if legacyPodNames(tang) {
+		labels["tang"] = "true"
+	}
+	return labels
+}
+
+// 选择集群中某个角色的 pod 的标签
+func roleLabels(tang *appsv1.YellowTang, role string) map[string]string {
+	selectLabels := clusterLabels(tang)
+	selectLabels["role"] = role
+	return selectLabels
+}
+
+// 在选择 pod 的标签上加上集群标签，调用方传入的标签不会选到其他集群的 pod
+func scopeLabels(selectLabels map[string]string, tang *appsv1.YellowTang) map[string]string {
+	scoped := clusterLabels(tang)
+	for key, value := range selectLabels {
+		scoped[key] = value
+	}
+	scoped[LabelCluster] = tang.Name
+	return scoped
+}
+
+// pod、PVC 和 ConfigMap 的名字：<cr>-mysql-<序号>，序号从 0 开始
+// 沿用旧名字的集群为 mysql-NN，序号 0 对应 mysql-01
+func mysqlPodName(tang *appsv1.YellowTang, ordinal int) string {
+	if legacyPodNames(tang) {
+		return fmt.Sprintf("mysql-%02d", ordinal+1)
+	}
+	return fmt.Sprintf("%s-mysql-%d", tang.Name, ordinal)
+}
+
+// 从 pod 名字中解析序号，<cr>-mysql-3 返回 3，不属于该集群的名字返回 false
+// 沿用旧名字的集群中 mysql-03 返回 2
+func parsePodNo(name string, tang *appsv1.YellowTang) (int, bool) {
+	prefix, offset := tang.Name+"-mysql-", 0
+	if legacyPodNames(tang) {
+		prefix, offset = "mysql-", 1
+	}
+	suffix, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(suffix)
+	if err != nil || ordinal < offset {
+		return 0, false
+	}
+	ordinal -= offset
+	if mysqlPodName(tang, ordinal) != name {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// server-id 不能为 0，使用序号加 1
+func serverID(ordinal int) int {
+	return ordinal + 1
+}
+
+// 无头 service 的名字，每个 pod 的 DNS 为 <pod>.<cr>-mysql.<namespace>.svc
+func headlessServiceName(tang *appsv1.YellowTang) string {
+	return fmt.Sprintf("%s-mysql", tang.Name)
+}
+
+// pod 在无头 service 下的 DNS 名字，组复制成员之间通过它互相连接
+func podFQDN(podName string, tang *appsv1.YellowTang) string {
+	return fmt.Sprintf("%s.%s.%s.svc", podName, headlessServiceName(tang), tang.Namespace)
+}
+
+// master service 的名字，未设置 spec.masterServiceName 时为 <cr>-master
+func masterServiceName(tang *appsv1.YellowTang) string {
+	if tang.Spec.MasterServiceName != "" {
+		return tang.Spec.MasterServiceName
+	}
+	return fmt.Sprintf("%s-master", tang.Name)
+}
+
+// slave service 的名字，未设置 spec.slaveServiceName 时为 <cr>-slave
+func slaveServiceName(tang *appsv1.YellowTang) string {
+	if tang.Spec.SlaveServiceName != "" {
+		return tang.Spec.SlaveServiceName
+	}
+	return fmt.Sprintf("%s-slave", tang.Name)
+}
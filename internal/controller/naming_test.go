@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+func TestPodNames(t *testing.T) {
+	current := &appsv1.YellowTang{ObjectMeta: metav1.ObjectMeta{Name: "demo"}}
+	legacy := &appsv1.YellowTang{ObjectMeta: metav1.ObjectMeta{
+		Name:        "demo",
+		Annotations: map[string]string{AnnotationLegacyPodNames: "true"},
+	}}
+
+	tests := []struct {
+		name    string
+		tang    *appsv1.YellowTang
+		pod     string
+		ordinal int
+		ok      bool
+	}{
+		{"first pod", current, "demo-mysql-0", 0, true},
+		{"two digits", current, "demo-mysql-12", 12, true},
+		{"leading zero", current, "demo-mysql-01", 0, false},
+		{"legacy name in a new cluster", current, "mysql-01", 0, false},
+		{"other cluster", current, "other-mysql-0", 0, false},
+		{"legacy first pod", legacy, "mysql-01", 0, true},
+		{"legacy third pod", legacy, "mysql-03", 2, true},
+		{"legacy three digits", legacy, "mysql-100", 99, true},
+		{"legacy without padding", legacy, "mysql-1", 0, false},
+		{"legacy mysql-00", legacy, "mysql-00", 0, false},
+		{"new name in a legacy cluster", legacy, "demo-mysql-0", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ordinal, ok := parsePodNo(tt.pod, tt.tang)
+			if ok != tt.ok || ordinal != tt.ordinal {
+				t.Fatalf("parsePodNo(%s) = %d, %v, want %d, %v", tt.pod, ordinal, ok, tt.ordinal, tt.ok)
+			}
+			if ok {
+				if got := mysqlPodName(tt.tang, ordinal); got != tt.pod {
+					t.Errorf("mysqlPodName(%d) = %s, want %s", ordinal, got, tt.pod)
+				}
+			}
+		})
+	}
+
+	if _, ok := clusterLabels(legacy)["tang"]; !ok {
+		t.Errorf("clusterLabels of a legacy cluster should keep tang=true for the old services")
+	}
+	if _, ok := clusterLabels(current)["tang"]; ok {
+		t.Errorf("clusterLabels of a new cluster should not contain tang=true")
+	}
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+const (
+	// 未设置 spec.podReadyTimeoutSeconds 时 pod 就绪的默认期限
+	defaultPodReadyTimeout = 300 * time.Second
+	// 等待 pod 就绪时的重新排队间隔
+	podReadyRequeueInterval = 5 * time.Second
+)
+
+// pod 就绪期限
+func podReadyTimeout(tang *appsv1.YellowTang) time.Duration {
+	if tang.Spec.PodReadyTimeoutSeconds > 0 {
+		return time.Duration(tang.Spec.PodReadyTimeoutSeconds) * time.Second
+	}
+	return defaultPodReadyTimeout
+}
+
+// pod 未就绪的原因，优先使用容器的 waiting reason（例如 ImagePullBackOff、CrashLoopBackOff）
+func podNotReadyReason(pod *corev1.Pod) string {
+	for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			if cs.State.Waiting.Message != "" {
+				return fmt.Sprintf("%s: %s", cs.State.Waiting.Reason, cs.State.Waiting.Message)
+			}
+			return cs.State.Waiting.Reason
+		}
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse {
+			return fmt.Sprintf("%s: %s", cond.Reason, cond.Message)
+		}
+	}
+	return fmt.Sprintf("pod phase %s", pod.Status.Phase)
+}
+
+// 检查一组 pod 的就绪情况并写入 PodsReady 条件
+// 返回是否全部就绪；超过就绪期限仍未就绪时集群被标记为 Degraded
+func markPodsReadiness(pods []corev1.Pod, tang *appsv1.YellowTang) bool {
+	deadline := podReadyTimeout(tang)
+	waiting := []string{}
+	timedOut := []string{}
+	for i := range pods {
+		pod := &pods[i]
+		if isPodHealthy(*pod) {
+			continue
+		}
+		// 克隆大量数据可能超过就绪期限，克隆进度见 status.clones
+		if cloneInProgress(tang, pod.Name) {
+			waiting = append(waiting, fmt.Sprintf("%s (cloning)", pod.Name))
+			continue
+		}
+		if time.Since(pod.CreationTimestamp.Time) > deadline {
+			timedOut = append(timedOut, fmt.Sprintf("%s (%s)", pod.Name, podNotReadyReason(pod)))
+		} else {
+			waiting = append(waiting, pod.Name)
+		}
+	}
+
+	switch {
+	case len(timedOut) > 0:
+		tang.Status.Phase = appsv1.ClusterPhaseDegraded
+		setCondition(tang, appsv1.ConditionPodsReady, metav1.ConditionFalse, "PodReadyTimeout",
+			fmt.Sprintf("not ready after %s: %s", deadline, strings.Join(timedOut, "; ")))
+	case len(waiting) > 0:
+		setCondition(tang, appsv1.ConditionPodsReady, metav1.ConditionFalse, "PodNotReady",
+			fmt.Sprintf("waiting for %s", strings.Join(waiting, ",")))
+	default:
+		setCondition(tang, appsv1.ConditionPodsReady, metav1.ConditionTrue, "PodsReady", "all pods are ready")
+	}
+	return len(timedOut) == 0 && len(waiting) == 0
+}
+
+// pod 是否已经超过就绪期限，超过期限的集群不能被标记为 Running
+func podsReadyTimedOut(tang *appsv1.YellowTang) bool {
+	cond := meta.FindStatusCondition(tang.Status.Conditions, appsv1.ConditionPodsReady)
+	return cond != nil && cond.Status == metav1.ConditionFalse && cond.Reason == "PodReadyTimeout"
+}
+
+// 合并两次调谐结果，取最早的重新排队时间
+func mergeResult(a, b ctrl.Result) ctrl.Result {
+	result := ctrl.Result{Requeue: a.Requeue || b.Requeue}
+	switch {
+	case a.RequeueAfter == 0:
+		result.RequeueAfter = b.RequeueAfter
+	case b.RequeueAfter == 0 || a.RequeueAfter < b.RequeueAfter:
+		result.RequeueAfter = a.RequeueAfter
+	default:
+		result.RequeueAfter = b.RequeueAfter
+	}
+	return result
+}
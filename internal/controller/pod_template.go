@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+// 创建 pod 时的 pod 模板 hash，和当前 spec 算出的不一致时 pod 需要重建
+const AnnotationPodTemplateHash = "yellowtang.kaxonliu.com/pod-template-hash"
+
+// 按当前 spec 渲染的 pod 模板的 hash
+// pod 名字、PVC、ConfigMap 和克隆的 init 容器每个 pod 各不相同，不参与计算
+func podTemplateHash(tang *appsv1.YellowTang) string {
+	pod := buildPod("", "", "", nil, tang)
+	data, _ := json.Marshal(pod.Spec)
+	hasher := fnv.New32a()
+	hasher.Write(data)
+	return fmt.Sprintf("%08x", hasher.Sum32())
+}
+
+// 旧版本 operator 创建的 pod 没有模板 hash，实际的 spec 和当前模板一致时补上 hash，不需要重建
+func (r *YellowTangReconciler) stampPodTemplateHash(ctx context.Context, pods []corev1.Pod, tang *appsv1.YellowTang) error {
+	hash := podTemplateHash(tang)
+	for i := range pods {
+		pod := &pods[i]
+		if _, ok := pod.Annotations[AnnotationPodTemplateHash]; ok || !podSpecMatches(pod, tang) {
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[AnnotationPodTemplateHash] = hash
+		if err := r.Patch(ctx, pod, patch); err != nil {
+			return fmt.Errorf("failed to annotate pod %s: %v", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// pod 实际的 spec 和当前模板渲染的是否一致
+// apiserver 会补上默认值、注入 ServiceAccount 的 volume，只比较模板中设置的字段，不比较克隆的 init 容器
+func podSpecMatches(pod *corev1.Pod, tang *appsv1.YellowTang) bool {
+	desired := buildPod(pod.Name, "", "", nil, tang).Spec
+	if pod.Spec.Hostname != desired.Hostname || pod.Spec.Subdomain != desired.Subdomain ||
+		len(pod.Spec.Containers) != len(desired.Containers) {
+		return false
+	}
+	for i, container := range desired.Containers {
+		actual := pod.Spec.Containers[i]
+		mounts := map[string]bool{}
+		for _, mount := range container.VolumeMounts {
+			mounts[mount.Name] = true
+		}
+		actualMounts := []corev1.VolumeMount{}
+		for _, mount := range actual.VolumeMounts {
+			if mounts[mount.Name] {
+				actualMounts = append(actualMounts, mount)
+			}
+		}
+		if actual.Name != container.Name || actual.Image != container.Image ||
+			!equality.Semantic.DeepEqual(actual.Command, container.Command) ||
+			!equality.Semantic.DeepEqual(actual.Args, container.Args) ||
+			!equality.Semantic.DeepEqual(actual.Env, container.Env) ||
+			!equality.Semantic.DeepEqual(actual.Resources, container.Resources) ||
+			!equality.Semantic.DeepEqual(actualMounts, container.VolumeMounts) {
+			return false
+		}
+	}
+	return true
+}
+
+// pod 模板 hash 和当前 spec 不一致、需要重建的 pod
+// 没有 hash 的 pod 是旧版本 operator 创建的，spec 和当前模板一致的已经由 stampPodTemplateHash 补上了 hash，剩下的按过期处理
+// 被隔离的 pod 不参与更新，重新加入集群后再重建
+func outdatedPods(pods []corev1.Pod, tang *appsv1.YellowTang) []string {
+	hash := podTemplateHash(tang)
+	outdated := []string{}
+	for i := range pods {
+		if pods[i].Annotations[AnnotationPodTemplateHash] != hash && !isFencedPod(&pods[i], tang) {
+			outdated = append(outdated, pods[i].Name)
+		}
+	}
+	sort.Strings(outdated)
+	return outdated
+}
+
+// pod 需要重建的原因
+func podUpdateReason(pod *corev1.Pod, tang *appsv1.YellowTang) string {
+	if podImage(pod) != tang.Spec.Image {
+		return fmt.Sprintf("image changed to %s", tang.Spec.Image)
+	}
+	return "pod template changed"
+}
+
+// pod 更新策略
+func updateStrategyType(tang *appsv1.YellowTang) appsv1.UpdateStrategyType {
+	if tang.Spec.UpdateStrategy.Type == "" {
+		return appsv1.UpdateStrategyRollingUpdate
+	}
+	return tang.Spec.UpdateStrategy.Type
+}
+
+// 滚动更新时最多同时不可用的从库数，百分比按 spec.replicas 向下取整，至少为 1
+func maxUnavailableReplicas(tang *appsv1.YellowTang) int {
+	maxUnavailable := tang.Spec.UpdateStrategy.MaxUnavailable
+	if maxUnavailable == nil {
+		return 1
+	}
+	n, err := intstr.GetScaledValueFromIntOrPercent(maxUnavailable, int(tang.Spec.Replicas), false)
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
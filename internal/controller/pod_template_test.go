@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+func TestPodSpecMatches(t *testing.T) {
+	tang := &appsv1.YellowTang{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec: appsv1.YellowTangSpec{
+			Replicas: 3,
+			Image:    "mysql:8.0",
+			Resources: appsv1.ResourcesConfig{
+				Requests: appsv1.BaseResource{CPU: "500m", Memory: "1Gi"},
+				Limits:   appsv1.BaseResource{CPU: "1", Memory: "2Gi"},
+			},
+		},
+	}
+	name := mysqlPodName(tang, 1)
+
+	tests := []struct {
+		name   string
+		mutate func(pod *corev1.Pod)
+		want   bool
+	}{
+		{"rendered from the current spec", func(pod *corev1.Pod) {}, true},
+		{"service account token injected", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts,
+				corev1.VolumeMount{Name: "kube-api-access-abcde", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount"})
+		}, true},
+		{"image differs", func(pod *corev1.Pod) { pod.Spec.Containers[0].Image = "mysql:5.7" }, false},
+		{"resources differ", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].Resources.Limits = nil
+		}, false},
+		{"env differs", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "MYSQL_ROOT_PASSWORD", Value: "secret"}}
+		}, false},
+		{"no hostname", func(pod *corev1.Pod) { pod.Spec.Hostname = "" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := buildPod(name, name, name, nil, tang)
+			tt.mutate(&pod)
+			if got := podSpecMatches(&pod, tang); got != tt.want {
+				t.Errorf("podSpecMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
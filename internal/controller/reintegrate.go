@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/gtid"
+	"yellowtang/internal/mysql"
+)
+
+// 查找 status 中记录的被隔离 pod
+func findFencedPod(tang *appsv1.YellowTang, name string) *appsv1.FencedPod {
+	for i := range tang.Status.FencedPods {
+		if tang.Status.FencedPods[i].Name == name {
+			return &tang.Status.FencedPods[i]
+		}
+	}
+	return nil
+}
+
+// pod 是否是还没有重新加入的旧主库
+// 按 Delete 方式隔离的 pod 重建后没有 fenced 标签，只能通过 status 判断
+func isFencedPod(pod *corev1.Pod, tang *appsv1.YellowTang) bool {
+	return pod.Labels["role"] == roleFenced || findFencedPod(tang, pod.Name) != nil
+}
+
+// 从 status 中去掉已经重新加入的 pod
+func removeFencedPod(tang *appsv1.YellowTang, name string) {
+	fencedPods := []appsv1.FencedPod{}
+	for _, fenced := range tang.Status.FencedPods {
+		if fenced.Name != name {
+			fencedPods = append(fencedPods, fenced)
+		}
+	}
+	tang.Status.FencedPods = fencedPods
+}
+
+// 隔离除当前主库外仍然带有 role=master 标签的 pod
+// 旧主库重启回来时可能还带着 master 标签，会和新主库一起挂到 master-service 上
+func (r *YellowTangReconciler) fenceStrayMasters(ctx context.Context, masterPodName string, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	masters, err := r.getAllPodByLabels(roleLabels(tang, "master"), ctx, tang)
+	if err != nil {
+		return err
+	}
+	for i := range masters {
+		pod := &masters[i]
+		if pod.Name == masterPodName {
+			continue
+		}
+		logger.Info("发现带有 master 标签的旧主库", "Pod", pod.Name, "当前主库", masterPodName)
+		method, err := r.fencePod(ctx, pod, tang)
+		if err != nil {
+			return fmt.Errorf("failed to fence stray master %s: %v", pod.Name, err)
+		}
+		recordFencedPod(tang, pod.Name, method)
+	}
+	return nil
+}
+
+// 让恢复的旧主库重新加入集群
+// 1. 旧主库 pod 就绪后，设置并确认 super_read_only，NetworkPolicy 隔离期间通过 exec 执行
+// 2. 比较它和新主库的 gtid_executed，找出新主库上没有的 errant 事务
+// 3. 没有 errant 事务就解除隔离，CHANGE MASTER TO 指向新主库，作为从库加入
+// 4. 有 errant 事务则保持隔离，在 status 中标记需要重新克隆
+func (r *YellowTangReconciler) reintegrateFencedPods(ctx context.Context, masterPodName string, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	if len(tang.Status.FencedPods) == 0 {
+		return nil
+	}
+
+	masterPod, creds, err := r.getPodAndCredentials(masterPodName, ctx, tang)
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, fenced := range append([]appsv1.FencedPod{}, tang.Status.FencedPods...) {
+		if fenced.NeedsReclone {
+			continue
+		}
+
+		pod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: fenced.Name}, ctx, tang)
+		if errors.IsNotFound(err) {
+			// 被删除的旧主库由副本数检查重建，重建后再处理
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if pod.Name == masterPodName {
+			// 旧主库又被选为主库了，不再需要重新加入
+			removeFencedPod(tang, pod.Name)
+			continue
+		}
+
+		rejoined, err := r.reintegratePod(ctx, pod, masterPod, creds, tang)
+		if err != nil {
+			logger.Info("旧主库重新加入失败", "Pod", pod.Name, "错误", err)
+			failed = append(failed, pod.Name)
+			continue
+		}
+		if rejoined {
+			logger.Info("旧主库已作为从库重新加入", "Pod", pod.Name, "主库", masterPodName)
+			removeFencedPod(tang, pod.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to reintegrate %s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// 重新加入一个旧主库，pod 还没就绪或者有 errant 事务时返回 false
+// 确认 super_read_only 已开启、没有 errant 事务之后才解除网络隔离，之前旧主库不会再接受写入
+func (r *YellowTangReconciler) reintegratePod(ctx context.Context, pod, masterPod *corev1.Pod, creds *mysqlCredentials, tang *appsv1.YellowTang) (bool, error) {
+	logger := log.FromContext(ctx)
+
+	if !isPodHealthy(*pod) {
+		return false, nil
+	}
+
+	// 重启后 super_read_only 会恢复成配置文件中的值，重新加入前再设置一次
+	executor := r.fencedPodSQL(pod)
+	target := rootTarget(pod, creds.RootPassword)
+	if err := executor.Exec(ctx, target, "SET GLOBAL super_read_only = ON"); err != nil {
+		return false, err
+	}
+	value, err := mysql.GetGlobalVariable(ctx, executor, target, "super_read_only")
+	if err != nil {
+		return false, err
+	}
+	if value != "ON" && value != "1" {
+		return false, fmt.Errorf("super_read_only is still %s on %s", value, pod.Name)
+	}
+
+	errant, err := r.getErrantGTIDSet(ctx, executor, pod, masterPod, creds)
+	if err != nil {
+		return false, err
+	}
+	if !errant.IsEmpty() {
+		logger.Info("旧主库有新主库上没有的事务，需要重新克隆", "Pod", pod.Name, "errant", errant.String())
+		if pod.Labels["role"] != roleFenced {
+			if err := r.labelPod(pod, roleFenced, ctx, tang); err != nil {
+				return false, err
+			}
+		}
+		fenced := findFencedPod(tang, pod.Name)
+		fenced.ErrantGtidSet = errant.String()
+		fenced.NeedsReclone = true
+		return false, nil
+	}
+
+	// 最后解除网络隔离，否则连不上主库；role=fenced 标签保证它不会被 service 选中
+	if _, ok := pod.Labels[LabelFenced]; ok {
+		delete(pod.Labels, LabelFenced)
+		if err := r.Update(ctx, pod); err != nil {
+			return false, fmt.Errorf("failed to update pod %s: %v", pod.Name, err)
+		}
+	}
+
+	if err := r.setupMasterSlaveReplication(ctx, masterPod.Name, []string{pod.Name}, tang); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 被 NetworkPolicy 隔离的 pod 连不上 TCP，通过 exec 进入 pod 用本地 socket 执行
+func (r *YellowTangReconciler) fencedPodSQL(pod *corev1.Pod) mysql.SQLExecutor {
+	if _, ok := pod.Labels[LabelFenced]; ok {
+		return mysql.NewExecExecutor(r.podExec)
+	}
+	return r.SQL
+}
+
+// pod 上执行过、但主库上没有的事务，pod 上的查询通过 executor 执行
+func (r *YellowTangReconciler) getErrantGTIDSet(ctx context.Context, executor mysql.SQLExecutor, pod, masterPod *corev1.Pod, creds *mysqlCredentials) (gtid.Set, error) {
+	podExecuted, err := mysql.GetExecutedGTIDSet(ctx, executor, rootTarget(pod, creds.RootPassword))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gtid_executed on %s: %v", pod.Name, err)
+	}
+	masterExecuted, err := mysql.GetExecutedGTIDSet(ctx, r.SQL, rootTarget(masterPod, creds.RootPassword))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gtid_executed on master %s: %v", masterPod.Name, err)
+	}
+
+	podSet, err := gtid.Parse(podExecuted)
+	if err != nil {
+		return nil, err
+	}
+	masterSet, err := gtid.Parse(masterExecuted)
+	if err != nil {
+		return nil, err
+	}
+	return podSet.Subtract(masterSet), nil
+}
+
+// 被隔离、需要重新克隆的 pod
+func quarantinedPods(tang *appsv1.YellowTang) []string {
+	names := []string{}
+	for _, fenced := range tang.Status.FencedPods {
+		if fenced.NeedsReclone {
+			names = append(names, fenced.Name)
+		}
+	}
+	return names
+}
+
+// 设置隔离相关的状态条件
+func setQuarantineCondition(tang *appsv1.YellowTang) {
+	if names := quarantinedPods(tang); len(names) > 0 {
+		setCondition(tang, appsv1.ConditionReplicasQuarantined, metav1.ConditionTrue, "ErrantTransactions",
+			fmt.Sprintf("%s have errant transactions and must be re-cloned", strings.Join(names, ",")))
+		return
+	}
+	setCondition(tang, appsv1.ConditionReplicasQuarantined, metav1.ConditionFalse, "NoErrantTransactions", "no replica is quarantined")
+}
@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+// 滚动重启时从库允许的最大复制延迟（秒），超过时认为重建的从库还没有追上主库
+const rolloutMaxReplicationLag int64 = 10
+
+// 一次滚动重启调谐的结果
+type rolloutStep struct {
+	// 集群没有通过健康检查或者这一步失败，等待之后的调谐继续
+	Paused bool
+	// 执行的动作或者暂停的原因
+	Message string
+}
+
+// 滚动重启前的健康检查结果
+type rolloutHealth struct {
+	// 不能继续滚动重启的原因：正在切换、复制中断或者超过就绪期限仍然不可用
+	Blocked string
+	// 正在重建、克隆、重新加入复制或者追赶主库的 pod，等它们恢复不算暂停
+	Unavailable []string
+}
+
+// 把配置变化和 pod 模板变化需要重建的 pod 合并起来，按 spec.updateStrategy 滚动重启
+// 同时更新 status 中过期的 pod 和镜像升级的进度
+func (r *YellowTangReconciler) reconcileRollout(ctx context.Context, masterPodName string, configRestart []string, tang *appsv1.YellowTang) (ctrl.Result, error) {
+	pods, err := r.getAllPodByLabels(clusterLabels(tang), ctx, tang)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.stampPodTemplateHash(ctx, pods, tang); err != nil {
+		return ctrl.Result{}, err
+	}
+	outdated := outdatedPods(pods, tang)
+	tang.Status.OutdatedPods = nil
+	if len(outdated) > 0 {
+		tang.Status.OutdatedPods = outdated
+	}
+	tang.Status.OutdatedReplicas = int32(len(outdated))
+	imageOutdated := outdatedImagePods(pods, tang)
+
+	restart := map[string]string{}
+	for _, name := range configRestart {
+		restart[name] = "mysql config changed"
+	}
+	for _, name := range outdated {
+		for i := range pods {
+			if pods[i].Name == name {
+				restart[name] = podUpdateReason(&pods[i], tang)
+			}
+		}
+	}
+	if len(restart) == 0 {
+		r.recordUpgradeProgress(imageOutdated, rolloutStep{}, tang)
+		return ctrl.Result{}, nil
+	}
+
+	// OnDelete 只报告，用户删除 pod 后由副本数检查按新模板重建
+	if updateStrategyType(tang) == appsv1.UpdateStrategyOnDelete {
+		names := make([]string, 0, len(restart))
+		for name := range restart {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		r.recordUpgradeProgress(imageOutdated, rolloutStep{
+			Message: fmt.Sprintf("update strategy is OnDelete, delete %s to update them", strings.Join(names, ",")),
+		}, tang)
+		return ctrl.Result{}, nil
+	}
+
+	step, err := r.rollingRestart(ctx, masterPodName, pods, restart, tang)
+	if err != nil {
+		step = rolloutStep{Paused: true, Message: err.Error()}
+	}
+	r.recordUpgradeProgress(imageOutdated, step, tang)
+	return ctrl.Result{RequeueAfter: podReadyRequeueInterval}, err
+}
+
+// 滚动重启 pod，先从库后主库，restart 的 value 是重启的原因
+// 从库每次最多重启 spec.updateStrategy.maxUnavailable 个，已经不可用的 pod 也计算在内
+// 集群中有复制中断、正在切换或者超过就绪期限的 pod 时暂停，恢复健康后继续
+// 轮到主库时等所有从库可用，先计划内切换到已经重启过的从库，旧主库变成从库后在之后的调谐中重启
+func (r *YellowTangReconciler) rollingRestart(ctx context.Context, masterPodName string, pods []corev1.Pod, restart map[string]string, tang *appsv1.YellowTang) (rolloutStep, error) {
+	logger := log.FromContext(ctx)
+
+	if switchoverInProgress(tang) {
+		return rolloutStep{Message: "switchover in progress"}, nil
+	}
+
+	names := make([]string, 0, len(restart))
+	for name := range restart {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// 没有就绪的从库已经不可用，直接重建，避免坏镜像修正之后滚动重启一直卡在它上面
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Name == masterPodName || restart[pod.Name] == "" {
+			continue
+		}
+		if !isPodHealthy(*pod) && !cloneInProgress(tang, pod.Name) {
+			if err := r.restartPod(ctx, pod.Name, restart[pod.Name], tang); err != nil {
+				return rolloutStep{}, err
+			}
+			return rolloutStep{Message: fmt.Sprintf("restarting %s: %s", pod.Name, restart[pod.Name])}, nil
+		}
+	}
+	replicas := []string{}
+	for _, name := range names {
+		if name != masterPodName {
+			replicas = append(replicas, name)
+		}
+	}
+
+	health := r.checkRolloutHealth(masterPodName, pods, tang)
+	if health.Blocked != "" {
+		logger.Info("集群不健康，暂停滚动重启", "原因", health.Blocked)
+		return rolloutStep{Paused: true, Message: health.Blocked}, nil
+	}
+	waiting := rolloutStep{Message: fmt.Sprintf("waiting for %s", strings.Join(health.Unavailable, ", "))}
+
+	if len(replicas) > 0 {
+		budget := maxUnavailableReplicas(tang) - len(health.Unavailable)
+		if budget <= 0 {
+			return waiting, nil
+		}
+		if budget < len(replicas) {
+			replicas = replicas[:budget]
+		}
+		for _, name := range replicas {
+			if err := r.restartPod(ctx, name, restart[name], tang); err != nil {
+				return rolloutStep{}, err
+			}
+		}
+		return rolloutStep{Message: fmt.Sprintf("restarting %s", strings.Join(replicas, ","))}, nil
+	}
+
+	// 只剩主库需要重启
+	if len(health.Unavailable) > 0 {
+		return waiting, nil
+	}
+	if tang.Spec.Replicas < 2 {
+		logger.Info("没有可以切换的从库，主库需要手动重启", "主库", masterPodName)
+		return rolloutStep{Paused: true, Message: fmt.Sprintf("no replica to switch over to, %s must be restarted manually", masterPodName)}, nil
+	}
+	// 组复制时主库离开组后由组选出新的主库，直接重启
+	if topology(tang) == appsv1.TopologyGroupReplication {
+		if err := r.restartPod(ctx, masterPodName, restart[masterPodName], tang); err != nil {
+			return rolloutStep{}, err
+		}
+		return rolloutStep{Message: fmt.Sprintf("restarting primary %s, the group elects a new primary", masterPodName)}, nil
+	}
+	target := selectSwitchoverTarget(masterPodName, names, tang)
+	if target == "" {
+		logger.Info("没有可以切换的从库，稍后重试", "主库", masterPodName)
+		return rolloutStep{Paused: true, Message: fmt.Sprintf("no healthy replica to switch over to from %s", masterPodName)}, nil
+	}
+
+	logger.Info("重启主库之前先切换主库", "主库", masterPodName, "新主库", target)
+	r.Recorder.Eventf(tang, corev1.EventTypeNormal, "SwitchoverForRestart", "switching master from %s to %s before restarting it: %s", masterPodName, target, restart[masterPodName])
+	if err := r.startSwitchover(ctx, masterPodName, target, appsv1.SwitchoverReasonRollingRestart, tang); err != nil {
+		return rolloutStep{}, err
+	}
+	if _, err := r.runSwitchover(ctx, tang); err != nil {
+		return rolloutStep{}, err
+	}
+	return rolloutStep{Message: fmt.Sprintf("switching master from %s to %s", masterPodName, target)}, nil
+}
+
+// 滚动重启前的健康检查
+// pod 没有就绪、没有重新加入复制或者复制中断时，在就绪期限内算作不可用，超过期限后阻止滚动重启
+// 从库延迟超过 rolloutMaxReplicationLag 时算作不可用；被隔离的 pod 不参与检查
+// 组复制时看成员状态，RECOVERING 的成员算作不可用
+func (r *YellowTangReconciler) checkRolloutHealth(masterPodName string, pods []corev1.Pod, tang *appsv1.YellowTang) rolloutHealth {
+	health := rolloutHealth{}
+	if missing := int(tang.Spec.Replicas) - len(pods); missing > 0 {
+		health.Unavailable = append(health.Unavailable, fmt.Sprintf("%d missing pods", missing))
+	}
+
+	replicas := map[string]appsv1.ReplicaStatus{}
+	for _, replica := range tang.Status.Replicas {
+		replicas[replica.Name] = replica
+	}
+	deadline := podReadyTimeout(tang)
+	group := topology(tang) == appsv1.TopologyGroupReplication
+	for i := range pods {
+		pod := &pods[i]
+		if isFencedPod(pod, tang) {
+			continue
+		}
+		if cloneInProgress(tang, pod.Name) {
+			health.Unavailable = append(health.Unavailable, fmt.Sprintf("%s (cloning)", pod.Name))
+			continue
+		}
+
+		problem := ""
+		replica, ok := replicas[pod.Name]
+		recovering := false
+		switch {
+		case !isPodHealthy(*pod):
+			problem = fmt.Sprintf("%s is not ready (%s)", pod.Name, podNotReadyReason(pod))
+		case pod.Name == masterPodName:
+		case group:
+			problem, recovering = groupMemberProblem(pod.Name, tang)
+		case !ok:
+			problem = fmt.Sprintf("%s has not rejoined replication", pod.Name)
+		case !replica.IOThreadRunning || !replica.SQLThreadRunning && !delayedReplicaStopActive(pod.Name, tang):
+			problem = fmt.Sprintf("replication is broken on %s", pod.Name)
+		}
+		if problem != "" {
+			if time.Since(pod.CreationTimestamp.Time) > deadline {
+				health.Blocked = problem
+				return health
+			}
+			health.Unavailable = append(health.Unavailable, pod.Name)
+			continue
+		}
+
+		// 延迟从库一直落后主库，不检查延迟
+		if recovering || pod.Name != masterPodName && !group && !isDelayedReplica(pod.Name, tang) && (replica.SecondsBehindMaster == nil || *replica.SecondsBehindMaster > rolloutMaxReplicationLag) {
+			health.Unavailable = append(health.Unavailable, fmt.Sprintf("%s (catching up)", pod.Name))
+		}
+	}
+	return health
+}
+
+// 选择切换的目标：复制正常、没有 errant 事务、不需要重启的非延迟从库中延迟最小的
+func selectSwitchoverTarget(masterPodName string, exclude []string, tang *appsv1.YellowTang) string {
+	excluded := map[string]bool{masterPodName: true}
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+
+	target := ""
+	var targetLag int64
+	for _, replica := range tang.Status.Replicas {
+		if excluded[replica.Name] || isDelayedReplica(replica.Name, tang) || !replica.IOThreadRunning || !replica.SQLThreadRunning || replica.ErrantGtidSet != "" {
+			continue
+		}
+		lag := int64(0)
+		if replica.SecondsBehindMaster != nil {
+			lag = *replica.SecondsBehindMaster
+		}
+		if target == "" || lag < targetLag {
+			target, targetLag = replica.Name, lag
+		}
+	}
+	return target
+}
+
+// 删除 pod，由副本数检查用原来的 PVC 和 ConfigMap 重新创建
+func (r *YellowTangReconciler) restartPod(ctx context.Context, name string, reason string, tang *appsv1.YellowTang) error {
+	pod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: name}, ctx, tang)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("重启 pod", "Pod", name, "原因", reason)
+	r.Recorder.Eventf(tang, corev1.EventTypeNormal, "RestartingPod", "restarting %s: %s", name, reason)
+	if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete pod %s: %v", name, err)
+	}
+	return nil
+}
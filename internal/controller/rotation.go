@@ -0,0 +1,319 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/gtid"
+	"yellowtang/internal/mysql"
+)
+
+const (
+	// 增大该 annotation 的值会触发一次账号密码轮换
+	// operator 生成的 Secret 会先换成新的随机密码，用户提供的 Secret 会重新下发一次
+	AnnotationRotationGeneration = "yellowtang.kaxonliu.com/credentials-rotation-generation"
+
+	// 等待从库追上主库、IO 线程重连时重新入队的间隔
+	rotationRequeueInterval = 2 * time.Second
+)
+
+// 期望的轮换代数
+func rotationGeneration(tang *appsv1.YellowTang) int64 {
+	generation, _ := strconv.ParseInt(tang.Annotations[AnnotationRotationGeneration], 10, 64)
+	return generation
+}
+
+// 账号密码轮换
+// 1. Secret 中的密码和库里实际的不一致，或者 annotation 的轮换代数变大时开始轮换
+// 2. 在主库上 ALTER USER，修改通过复制同步到从库
+// 3. 从库应用完主库的 GTID 后，在这个从库上用新的复制密码重新 CHANGE MASTER TO
+// 4. 确认所有从库的 IO 线程重新连上主库，记录轮换时间
+// 3、4 不阻塞调谐，进度记录在 status.credentials 中，没有完成时重新入队
+// 没有就绪的从库也要等它就绪后更新完，轮换才算完成
+func (r *YellowTangReconciler) reconcileCredentialRotation(ctx context.Context, masterPodName string, tang *appsv1.YellowTang) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if tang.Status.Credentials == nil {
+		tang.Status.Credentials = &appsv1.CredentialsStatus{}
+	}
+	status := tang.Status.Credentials
+
+	desired, err := r.getDesiredCredentials(ctx, tang)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// annotation 触发的轮换：operator 生成的 Secret 先换成新密码
+	generation := rotationGeneration(tang)
+	if generation > status.ObservedRotationGeneration && tang.Spec.Credentials.SecretName == "" {
+		if desired, err = r.regenerateCredentialsSecret(ctx, tang); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to regenerate credentials: %v", err)
+		}
+	}
+
+	applied, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	masterChanged := *desired != *applied || generation > status.ObservedRotationGeneration
+	if !masterChanged && !status.ReplicasPending {
+		setCondition(tang, appsv1.ConditionCredentialsSynced, metav1.ConditionTrue, "Synced", "credentials match the secret")
+		return ctrl.Result{}, nil
+	}
+
+	masterPod := &corev1.Pod{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: tang.Namespace, Name: masterPodName}, masterPod); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get master pod %s: %v", masterPodName, err)
+	}
+
+	if masterChanged {
+		logger.Info("开始轮换账号密码", "master", masterPodName, "generation", generation)
+		setCondition(tang, appsv1.ConditionCredentialsSynced, metav1.ConditionFalse, "Rotating", "altering users on master")
+
+		if err := r.alterUsersOnMaster(ctx, masterPod, applied, desired); err != nil {
+			setCondition(tang, appsv1.ConditionCredentialsSynced, metav1.ConditionFalse, "RotationFailed", err.Error())
+			return ctrl.Result{}, err
+		}
+
+		// 从库应用完这时主库上的事务，才能用新密码登录
+		masterGTID, err := mysql.GetExecutedGTIDSet(ctx, r.SQL, rootTarget(masterPod, desired.RootPassword))
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to read gtid_executed on master: %v", err)
+		}
+		// 主库已经是新密码，先记下来，后面的步骤失败了也能从这里继续
+		if err := r.saveAppliedCredentials(desired, ctx, tang); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to save applied credentials: %v", err)
+		}
+		status.ReplicasPending = true
+		status.ObservedRotationGeneration = generation
+		status.TargetGTIDSet = masterGTID
+		status.RepointedReplicas = nil
+		if err := r.updateStatus(ctx, tang); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	waiting, err := r.repointReplicasAfterRotation(ctx, masterPod, desired, tang)
+	if err != nil {
+		setCondition(tang, appsv1.ConditionCredentialsSynced, metav1.ConditionFalse, "RotationFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+	if len(waiting) > 0 {
+		setCondition(tang, appsv1.ConditionCredentialsSynced, metav1.ConditionFalse, "Rotating", strings.Join(waiting, "; "))
+		return ctrl.Result{RequeueAfter: rotationRequeueInterval}, nil
+	}
+
+	now := metav1.Now()
+	status.ReplicasPending = false
+	status.TargetGTIDSet = ""
+	status.RepointedReplicas = nil
+	status.LastRotationTime = &now
+	setCondition(tang, appsv1.ConditionCredentialsSynced, metav1.ConditionTrue, "Rotated", fmt.Sprintf("credentials rotated at %s", now.UTC().Format(time.RFC3339)))
+	logger.Info("账号密码轮换完成")
+	return ctrl.Result{}, r.updateStatus(ctx, tang)
+}
+
+// 为 operator 生成的 Secret 换一组新的随机密码
+func (r *YellowTangReconciler) regenerateCredentialsSecret(ctx context.Context, tang *appsv1.YellowTang) (*mysqlCredentials, error) {
+	secret, err := r.getorCreateCredentialsSecret(ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := credentialsFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	if creds.RootPassword, err = generatePassword(generatedPasswordLength); err != nil {
+		return nil, err
+	}
+	if creds.ReplicationPassword, err = generatePassword(generatedPasswordLength); err != nil {
+		return nil, err
+	}
+	secret.Data = credentialsData(creds)
+	if err := r.Update(ctx, secret); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// 在主库上修改 root 和复制账号的密码，修改会写入 binlog 同步到所有从库
+func (r *YellowTangReconciler) alterUsersOnMaster(ctx context.Context, masterPod *corev1.Pod, applied, desired *mysqlCredentials) error {
+	rootPassword := mysql.QuoteString(desired.RootPassword)
+	user := mysql.QuoteString(desired.ReplicationUser)
+	password := mysql.QuoteString(desired.ReplicationPassword)
+	statements := []string{
+		fmt.Sprintf("ALTER USER IF EXISTS 'root'@'%%' IDENTIFIED BY %s", rootPassword),
+		fmt.Sprintf("ALTER USER IF EXISTS 'root'@'localhost' IDENTIFIED BY %s", rootPassword),
+		fmt.Sprintf("CREATE USER IF NOT EXISTS %s@'%%' IDENTIFIED BY %s", user, password),
+		fmt.Sprintf("ALTER USER %s@'%%' IDENTIFIED BY %s", user, password),
+		fmt.Sprintf("GRANT REPLICATION SLAVE ON *.* TO %s@'%%'", user),
+	}
+
+	// 重试时主库可能已经是新密码了
+	err := r.withRootPasswords(masterPod, func(target mysql.Target) error {
+		return r.SQL.Exec(ctx, target, statements...)
+	}, applied.RootPassword, desired.RootPassword)
+	if err != nil {
+		return fmt.Errorf("failed to alter users on master %s: %v", masterPod.Name, err)
+	}
+	return nil
+}
+
+// 从库应用完主库上的密码修改后，用新的复制密码重新配置主从，并确认 IO 线程重新连上
+// 每次调谐只检查一遍，返回还在等待的从库和原因，更新过的从库记录在 status.credentials.repointedReplicas
+func (r *YellowTangReconciler) repointReplicasAfterRotation(ctx context.Context, masterPod *corev1.Pod, desired *mysqlCredentials, tang *appsv1.YellowTang) ([]string, error) {
+	status := tang.Status.Credentials
+	previousRootPassword, err := r.getPreviousRootPassword(ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+
+	// 升级前开始的轮换没有记录目标 GTID，用主库当前的 GTID
+	if status.TargetGTIDSet == "" {
+		if status.TargetGTIDSet, err = mysql.GetExecutedGTIDSet(ctx, r.SQL, rootTarget(masterPod, desired.RootPassword)); err != nil {
+			return nil, fmt.Errorf("failed to read gtid_executed on master: %v", err)
+		}
+	}
+	target, err := gtid.Parse(status.TargetGTIDSet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse target gtid set %q: %v", status.TargetGTIDSet, err)
+	}
+
+	// 没有就绪的从库也要列出来，否则它会一直用旧的复制密码
+	slavePods, err := r.getAllPodByLabels(roleLabels(tang, "slave"), ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+	delayedPods, err := r.getAllPodByLabels(roleLabels(tang, roleDelayed), ctx, tang)
+	if err != nil {
+		return nil, err
+	}
+	slavePods = append(slavePods, delayedPods...)
+
+	repointed := map[string]bool{}
+	for _, name := range status.RepointedReplicas {
+		repointed[name] = true
+	}
+	waiting := []string{}
+	for i := range slavePods {
+		slavePod := &slavePods[i]
+		if repointed[slavePod.Name] {
+			continue
+		}
+		if !isPodHealthy(*slavePod) {
+			waiting = append(waiting, fmt.Sprintf("waiting for %s to become ready", slavePod.Name))
+			continue
+		}
+
+		// 延迟从库要过了 MASTER_DELAY 才会应用主库上的密码修改，不等待，先在本地修改 root 密码
+		if isDelayedReplica(slavePod.Name, tang) {
+			if err := r.alterRootPasswordLocally(ctx, slavePod, desired, previousRootPassword); err != nil {
+				return nil, err
+			}
+		} else {
+			caughtUp, err := r.replicaCaughtUp(ctx, slavePod, target, desired, previousRootPassword)
+			if err != nil {
+				return nil, err
+			}
+			if !caughtUp {
+				waiting = append(waiting, fmt.Sprintf("waiting for %s to apply the credential change", slavePod.Name))
+				continue
+			}
+		}
+
+		if err := r.execSQL(ctx, slavePod, desired,
+			"STOP SLAVE IO_THREAD",
+			fmt.Sprintf("CHANGE MASTER TO MASTER_USER=%s, MASTER_PASSWORD=%s",
+				mysql.QuoteString(desired.ReplicationUser), mysql.QuoteString(desired.ReplicationPassword)),
+			"START SLAVE IO_THREAD",
+		); err != nil {
+			return nil, fmt.Errorf("failed to update replication password on %s: %v", slavePod.Name, err)
+		}
+		status.RepointedReplicas = append(status.RepointedReplicas, slavePod.Name)
+		repointed[slavePod.Name] = true
+	}
+
+	// 确认更新过的从库的 IO 线程已经用新密码重新连上主库
+	for i := range slavePods {
+		slavePod := &slavePods[i]
+		if !repointed[slavePod.Name] {
+			continue
+		}
+		if !isPodHealthy(*slavePod) {
+			waiting = append(waiting, fmt.Sprintf("waiting for %s to become ready", slavePod.Name))
+			continue
+		}
+		replica, err := r.getReplicaStatus(ctx, slavePod, desired)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check io thread on %s: %v", slavePod.Name, err)
+		}
+		if replicaStatus := replicaStatusFromMySQL(slavePod.Name, replica); !replicaStatus.IOThreadRunning {
+			waiting = append(waiting, fmt.Sprintf("waiting for io thread on %s to reconnect: %s", slavePod.Name, replicaStatus.LastError))
+		}
+	}
+	return waiting, nil
+}
+
+// 从库是否已经应用了主库上的密码修改
+// 从库还没有应用到主库的密码修改时只能用旧的 root 密码登录
+func (r *YellowTangReconciler) replicaCaughtUp(ctx context.Context, pod *corev1.Pod, target gtid.Set, desired *mysqlCredentials, previousRootPassword string) (bool, error) {
+	executed := ""
+	err := r.withRootPasswords(pod, func(t mysql.Target) error {
+		var err error
+		executed, err = mysql.GetExecutedGTIDSet(ctx, r.SQL, t)
+		return err
+	}, desired.RootPassword, previousRootPassword)
+	if err != nil {
+		return false, fmt.Errorf("failed to read gtid_executed on %s: %v", pod.Name, err)
+	}
+	executedSet, err := gtid.Parse(executed)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse gtid_executed of %s: %v", pod.Name, err)
+	}
+	return executedSet.Contains(target), nil
+}
+
+// 在延迟从库本地修改 root 密码，不写 binlog
+// 之后应用到主库上的 ALTER USER 时密码不变，延迟期间 operator 可以用新密码登录
+func (r *YellowTangReconciler) alterRootPasswordLocally(ctx context.Context, pod *corev1.Pod, desired *mysqlCredentials, previousRootPassword string) error {
+	rootPassword := mysql.QuoteString(desired.RootPassword)
+	err := r.withRootPasswords(pod, func(target mysql.Target) error {
+		return r.SQL.Exec(ctx, target,
+			"SET GLOBAL super_read_only = OFF",
+			"SET SQL_LOG_BIN = 0",
+			fmt.Sprintf("ALTER USER IF EXISTS 'root'@'%%' IDENTIFIED BY %s", rootPassword),
+			fmt.Sprintf("ALTER USER IF EXISTS 'root'@'localhost' IDENTIFIED BY %s", rootPassword),
+			"SET SQL_LOG_BIN = 1",
+			"SET GLOBAL super_read_only = ON",
+		)
+	}, desired.RootPassword, previousRootPassword)
+	if err != nil {
+		return fmt.Errorf("failed to alter root password on delayed replica %s: %v", pod.Name, err)
+	}
+	return nil
+}
+
+// 依次用多个 root 密码连接 pod 执行 fn，直到成功
+func (r *YellowTangReconciler) withRootPasswords(pod *corev1.Pod, fn func(target mysql.Target) error, rootPasswords ...string) error {
+	var lastErr error
+	for _, password := range rootPasswords {
+		if password == "" {
+			continue
+		}
+		if lastErr = fn(rootTarget(pod, password)); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
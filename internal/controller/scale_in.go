@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+// 正在缩容的 pod 的 role 标签，不会被 slave-service 选中
+const roleRemoving = "removing"
+
+// 缩容时 PVC 和 ConfigMap 的处理方式
+func retentionPolicy(tang *appsv1.YellowTang) appsv1.RetentionPolicy {
+	if tang.Spec.Storage.RetentionPolicy == "" {
+		return appsv1.RetentionPolicyRetain
+	}
+	return tang.Spec.Storage.RetentionPolicy
+}
+
+// 选出要缩掉的 pod：编号最大的非主库 pod
+// 主库永远不会被选中，需要先通过 spec.switchover 把主库切走
+func selectScaleInPods(pods []corev1.Pod, count int, tang *appsv1.YellowTang) []corev1.Pod {
+	candidates := []corev1.Pod{}
+	for _, pod := range pods {
+		if pod.Name == tang.Status.MasterPod || pod.Labels["role"] == "master" {
+			continue
+		}
+		candidates = append(candidates, pod)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, _ := parsePodNo(candidates[i].Name, tang)
+		b, _ := parsePodNo(candidates[j].Name, tang)
+		return a > b
+	})
+	if count > len(candidates) {
+		count = len(candidates)
+	}
+	return candidates[:count]
+}
+
+// 缩容
+// 1. 选出编号最大的非主库 pod
+// 2. 改掉 role 标签，从 slave-service 中摘掉
+// 3. 停止复制并清除复制配置
+// 4. 删除 pod，按 spec.storage.retentionPolicy 删除或保留 PVC 和 ConfigMap
+func (r *YellowTangReconciler) scaleIn(ctx context.Context, actualPods []corev1.Pod, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+
+	// 主库不确定或者正在切换时不缩容，避免删掉切换的目标
+	if tang.Status.MasterPod == "" || switchoverInProgress(tang) {
+		logger.Info("主库不确定或者正在切换，暂不缩容")
+		return nil
+	}
+
+	count := len(actualPods) - int(tang.Spec.Replicas)
+	removePods := selectScaleInPods(actualPods, count, tang)
+	if len(removePods) < count {
+		logger.Info("只剩下主库，不能继续缩容", "主库", tang.Status.MasterPod)
+	}
+
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return err
+	}
+
+	for i := range removePods {
+		pod := &removePods[i]
+		logger.Info("缩容删除 pod", "Pod", pod.Name)
+
+		if pod.Labels["role"] != roleRemoving {
+			if err := r.labelPod(pod, roleRemoving, ctx, tang); err != nil {
+				return err
+			}
+		}
+
+		// pod 可能已经不可用，停止复制失败不影响删除
+		// 组复制时先离开组，组中剩下的成员不会把它当作失联的成员
+		stop := []string{"STOP SLAVE", "RESET SLAVE ALL"}
+		if topology(tang) == appsv1.TopologyGroupReplication {
+			stop = []string{"STOP GROUP_REPLICATION"}
+		}
+		if isPodHealthy(*pod) {
+			if err := r.execSQL(ctx, pod, creds, stop...); err != nil {
+				logger.Info("停止复制失败", "Pod", pod.Name, "错误", err)
+			}
+		}
+
+		if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pod %s: %v", pod.Name, err)
+		}
+		removeFencedPod(tang, pod.Name)
+		removeCloneStatus(tang, pod.Name)
+
+		if err := r.cleanupPodStorage(ctx, pod.Name, tang); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按保留策略清理缩容 pod 的 PVC 和 ConfigMap，两者和 pod 同名，不是该集群创建的不删除
+func (r *YellowTangReconciler) cleanupPodStorage(ctx context.Context, podName string, tang *appsv1.YellowTang) error {
+	if retentionPolicy(tang) != appsv1.RetentionPolicyDelete {
+		return nil
+	}
+
+	key := client.ObjectKey{Namespace: tang.Namespace, Name: podName}
+	pvc, err := r.getPVC(key, ctx, tang)
+	if err == nil && metav1.IsControlledBy(pvc, tang) {
+		if err := r.Delete(ctx, pvc); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pvc %s: %v", podName, err)
+		}
+	} else if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	cm, err := r.getConfigMap(key, ctx, tang)
+	if err == nil && metav1.IsControlledBy(cm, tang) {
+		if err := r.Delete(ctx, cm); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete configmap %s: %v", podName, err)
+		}
+	} else if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/mysql"
+)
+
+const (
+	semisyncMasterPlugin = "rpl_semi_sync_master"
+	semisyncSlavePlugin  = "rpl_semi_sync_slave"
+
+	// 未设置 spec.replication 时的默认值
+	defaultSemisyncWaitForSlaveCount = 1
+	defaultSemisyncTimeout           = 10 * time.Second
+)
+
+// 半同步插件和对应的动态库，主库和从库都安装两个插件，切换角色时不需要再安装
+var semisyncPlugins = []struct {
+	name    string
+	library string
+	enabled string
+}{
+	{semisyncMasterPlugin, "semisync_master.so", "rpl_semi_sync_master_enabled"},
+	{semisyncSlavePlugin, "semisync_slave.so", "rpl_semi_sync_slave_enabled"},
+}
+
+// 主从复制方式
+func replicationMode(tang *appsv1.YellowTang) appsv1.ReplicationMode {
+	if tang.Spec.Replication.Mode == "" {
+		return appsv1.ReplicationModeAsync
+	}
+	return tang.Spec.Replication.Mode
+}
+
+// 需要配置半同步：当前是半同步，或者之前是半同步、还没有在所有 pod 上关闭
+func semisyncManaged(tang *appsv1.YellowTang) bool {
+	return replicationMode(tang) == appsv1.ReplicationModeSemisync || tang.Status.Semisync != nil
+}
+
+// 主库提交前需要等待确认的从库数
+func semisyncWaitForSlaveCount(tang *appsv1.YellowTang) int32 {
+	if tang.Spec.Replication.WaitForSlaveCount > 0 {
+		return tang.Spec.Replication.WaitForSlaveCount
+	}
+	return defaultSemisyncWaitForSlaveCount
+}
+
+// 主库等待从库确认的最长时间
+func semisyncTimeout(tang *appsv1.YellowTang) time.Duration {
+	if tang.Spec.Replication.TimeoutSeconds > 0 {
+		return time.Duration(tang.Spec.Replication.TimeoutSeconds) * time.Second
+	}
+	return defaultSemisyncTimeout
+}
+
+// 安装半同步插件，已经安装的跳过
+// INSTALL PLUGIN 会写入 mysql.plugin 表，pod 重启后插件自动加载
+func (r *YellowTangReconciler) installSemisyncPlugins(ctx context.Context, pod *corev1.Pod, creds *mysqlCredentials) error {
+	target := rootTarget(pod, creds.RootPassword)
+	for _, plugin := range semisyncPlugins {
+		active, err := mysql.IsPluginActive(ctx, r.SQL, target, plugin.name)
+		if err != nil {
+			return fmt.Errorf("failed to check plugin %s on %s: %v", plugin.name, pod.Name, err)
+		}
+		if active {
+			continue
+		}
+		if err := r.SQL.Exec(ctx, target, fmt.Sprintf("INSTALL PLUGIN %s SONAME %s", plugin.name, mysql.QuoteString(plugin.library))); err != nil {
+			return fmt.Errorf("failed to install plugin %s on %s: %v", plugin.name, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// 关闭半同步，没有安装插件时跳过
+func (r *YellowTangReconciler) disableSemisync(ctx context.Context, pod *corev1.Pod, creds *mysqlCredentials) error {
+	target := rootTarget(pod, creds.RootPassword)
+	for _, plugin := range semisyncPlugins {
+		active, err := mysql.IsPluginActive(ctx, r.SQL, target, plugin.name)
+		if err != nil {
+			return fmt.Errorf("failed to check plugin %s on %s: %v", plugin.name, pod.Name, err)
+		}
+		if !active {
+			continue
+		}
+		if err := r.SQL.Exec(ctx, target, fmt.Sprintf("SET GLOBAL %s = OFF", plugin.enabled)); err != nil {
+			return fmt.Errorf("failed to disable %s on %s: %v", plugin.enabled, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// 按 spec.replication 配置主库的半同步
+// 被提升的从库要关掉从库端的半同步
+func (r *YellowTangReconciler) configureSemisyncMaster(ctx context.Context, pod *corev1.Pod, creds *mysqlCredentials, tang *appsv1.YellowTang) error {
+	if replicationMode(tang) != appsv1.ReplicationModeSemisync {
+		return r.disableSemisync(ctx, pod, creds)
+	}
+	if err := r.installSemisyncPlugins(ctx, pod, creds); err != nil {
+		return err
+	}
+	if err := r.execSQL(ctx, pod, creds,
+		"SET GLOBAL rpl_semi_sync_slave_enabled = OFF",
+		fmt.Sprintf("SET GLOBAL rpl_semi_sync_master_wait_for_slave_count = %d", semisyncWaitForSlaveCount(tang)),
+		fmt.Sprintf("SET GLOBAL rpl_semi_sync_master_timeout = %d", semisyncTimeout(tang).Milliseconds()),
+		"SET GLOBAL rpl_semi_sync_master_enabled = ON",
+	); err != nil {
+		return fmt.Errorf("failed to enable semisync on master %s: %v", pod.Name, err)
+	}
+	return nil
+}
+
+// 按 spec.replication 配置从库的半同步，返回从库端的半同步是否从关闭变为开启
+// 变为开启后 IO 线程重新连接主库才会生效；被降级的旧主库要关掉主库端的半同步
+func (r *YellowTangReconciler) configureSemisyncSlave(ctx context.Context, pod *corev1.Pod, creds *mysqlCredentials, tang *appsv1.YellowTang) (bool, error) {
+	if replicationMode(tang) != appsv1.ReplicationModeSemisync {
+		return false, r.disableSemisync(ctx, pod, creds)
+	}
+	if err := r.installSemisyncPlugins(ctx, pod, creds); err != nil {
+		return false, err
+	}
+	enabled, err := mysql.GetGlobalVariable(ctx, r.SQL, rootTarget(pod, creds.RootPassword), "rpl_semi_sync_slave_enabled")
+	if err != nil {
+		return false, err
+	}
+	if err := r.execSQL(ctx, pod, creds,
+		"SET GLOBAL rpl_semi_sync_master_enabled = OFF",
+		"SET GLOBAL rpl_semi_sync_slave_enabled = ON",
+	); err != nil {
+		return false, fmt.Errorf("failed to enable semisync on replica %s: %v", pod.Name, err)
+	}
+	return enabled != "ON", nil
+}
+
+// 每次调谐确认半同步配置：pod 重启后 SET GLOBAL 的值会丢失，spec.replication 也可能被修改
+// 半同步时把主库的半同步状态写入 status.semisync，退化为异步时记录 Event
+// 改回异步并在所有 pod 上关闭后清空 status.semisync
+func (r *YellowTangReconciler) reconcileSemisync(ctx context.Context, masterPodName string, slavePods []corev1.Pod, tang *appsv1.YellowTang) error {
+	if !semisyncManaged(tang) {
+		return nil
+	}
+	logger := log.FromContext(ctx)
+
+	masterPod, creds, err := r.getPodAndCredentials(masterPodName, ctx, tang)
+	if err != nil {
+		return err
+	}
+	if err := r.configureSemisyncMaster(ctx, masterPod, creds, tang); err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for i := range slavePods {
+		pod := &slavePods[i]
+		if !isPodHealthy(*pod) {
+			continue
+		}
+		changed, err := r.configureSemisyncSlave(ctx, pod, creds, tang)
+		if err == nil && changed {
+			logger.Info("从库开启半同步，重启 IO 线程", "Pod", pod.Name)
+			err = r.execSQL(ctx, pod, creds, "STOP SLAVE IO_THREAD", "START SLAVE IO_THREAD")
+		}
+		if err != nil {
+			logger.Info("配置从库半同步失败", "Pod", pod.Name, "错误", err)
+			failed = append(failed, pod.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to configure semisync on %s", strings.Join(failed, ","))
+	}
+
+	if replicationMode(tang) != appsv1.ReplicationModeSemisync {
+		tang.Status.Semisync = nil
+		meta.RemoveStatusCondition(&tang.Status.Conditions, appsv1.ConditionSemisyncActive)
+		return nil
+	}
+	return r.updateSemisyncStatus(ctx, masterPod, creds, tang)
+}
+
+// 从主库的状态变量读取半同步状态
+func (r *YellowTangReconciler) updateSemisyncStatus(ctx context.Context, masterPod *corev1.Pod, creds *mysqlCredentials, tang *appsv1.YellowTang) error {
+	target := rootTarget(masterPod, creds.RootPassword)
+	masterStatus, err := mysql.GetGlobalStatus(ctx, r.SQL, target, "Rpl_semi_sync_master_status")
+	if err != nil {
+		return fmt.Errorf("failed to read semisync status on %s: %v", masterPod.Name, err)
+	}
+	clientsValue, err := mysql.GetGlobalStatus(ctx, r.SQL, target, "Rpl_semi_sync_master_clients")
+	if err != nil {
+		return fmt.Errorf("failed to read semisync clients on %s: %v", masterPod.Name, err)
+	}
+	clients, _ := strconv.Atoi(clientsValue)
+
+	previous := tang.Status.Semisync
+	status := &appsv1.SemisyncStatus{
+		MasterActive: masterStatus == "ON",
+		Clients:      int32(clients),
+	}
+	if previous != nil {
+		status.LastFallbackTime = previous.LastFallbackTime
+	}
+	if !status.MasterActive && previous != nil && previous.MasterActive {
+		now := metav1.Now()
+		status.LastFallbackTime = &now
+		r.Recorder.Eventf(tang, corev1.EventTypeWarning, "SemisyncFallback",
+			"master %s fell back to async replication, %d semisync replicas connected, %d required", masterPod.Name, clients, semisyncWaitForSlaveCount(tang))
+	}
+	tang.Status.Semisync = status
+
+	if status.MasterActive {
+		setCondition(tang, appsv1.ConditionSemisyncActive, metav1.ConditionTrue, "SemisyncActive",
+			fmt.Sprintf("master %s waits for %d of %d semisync replicas", masterPod.Name, semisyncWaitForSlaveCount(tang), clients))
+	} else {
+		setCondition(tang, appsv1.ConditionSemisyncActive, metav1.ConditionFalse, "FellBackToAsync",
+			fmt.Sprintf("master %s is replicating asynchronously, %d semisync replicas connected, %d required", masterPod.Name, clients, semisyncWaitForSlaveCount(tang)))
+	}
+	return nil
+}
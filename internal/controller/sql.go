@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"yellowtang/internal/mysql"
+)
+
+// 以 root 身份连接 pod 上的 MySQL
+func rootTarget(pod *corev1.Pod, rootPassword string) mysql.Target {
+	return mysql.Target{
+		Pod:      pod,
+		Host:     pod.Status.PodIP,
+		Port:     mysql.DefaultPort,
+		User:     "root",
+		Password: rootPassword,
+	}
+}
+
+// 提供给 exec 后端的 pod exec 函数
+func (r *YellowTangReconciler) podExec(ctx context.Context, pod *corev1.Pod, command string) (string, error) {
+	return r.execCommandOnPod(ctx, pod, command)
+}
+
+// 在 pod 上执行 sql 语句
+func (r *YellowTangReconciler) execSQL(ctx context.Context, pod *corev1.Pod, creds *mysqlCredentials, statements ...string) error {
+	return r.SQL.Exec(ctx, rootTarget(pod, creds.RootPassword), statements...)
+}
+
+// 查询 pod 的从库状态
+func (r *YellowTangReconciler) getReplicaStatus(ctx context.Context, pod *corev1.Pod, creds *mysqlCredentials) (*mysql.ReplicaStatus, error) {
+	return mysql.GetReplicaStatus(ctx, r.SQL, rootTarget(pod, creds.RootPassword))
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/mysql"
+)
+
+// 设置状态条件，状态未变化时保留原来的 LastTransitionTime
+func setCondition(tang *appsv1.YellowTang, condType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&tang.Status.Conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: tang.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// 通过 status 子资源写回集群状态
+// 以 apiserver 上当前的 status 为基准做 merge patch，不带 resourceVersion，gtid 快照等并发写入不会导致冲突
+// status.masterGTIDSnapshot 只由 gtidSnapshotCollector 写入，这里保留 apiserver 上的值
+func (r *YellowTangReconciler) updateStatus(ctx context.Context, tang *appsv1.YellowTang) error {
+	tang.Status.ObservedGeneration = tang.Generation
+
+	var reader client.Reader = r.Client
+	if r.apiReader != nil {
+		reader = r.apiReader
+	}
+	current := &appsv1.YellowTang{}
+	if err := reader.Get(ctx, client.ObjectKeyFromObject(tang), current); err != nil {
+		return err
+	}
+	base := tang.DeepCopy()
+	base.Status = current.Status
+	tang.Status.MasterGTIDSnapshot = current.Status.MasterGTIDSnapshot
+	return r.Status().Patch(ctx, tang, client.MergeFrom(base))
+}
+
+// 根据 SHOW SLAVE STATUS 的结果构造从库状态
+func replicaStatusFromMySQL(podName string, replica *mysql.ReplicaStatus) appsv1.ReplicaStatus {
+	if replica == nil {
+		return appsv1.ReplicaStatus{Name: podName, LastError: "replication is not configured"}
+	}
+	return appsv1.ReplicaStatus{
+		Name:                podName,
+		IOThreadRunning:     replica.IOThreadRunning,
+		SQLThreadRunning:    replica.SQLThreadRunning,
+		SecondsBehindMaster: replica.SecondsBehindMaster,
+		ExecutedGtidSet:     replica.ExecutedGtidSet,
+		LastError:           replica.LastError(),
+		DelaySeconds:        int32(replica.SQLDelay),
+		Source:              replica.MasterHost,
+	}
+}
@@ -0,0 +1,380 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/mysql"
+)
+
+const (
+	// 未设置 spec.switchover.timeoutSeconds 时等待目标从库追平的默认时间
+	defaultSwitchoverTimeout = 30 * time.Second
+	// 等待 master-service 指向新主库时重新入队的间隔
+	switchoverRequeueInterval = time.Second
+)
+
+// 切换中每一步的处理函数
+type switchoverStepFunc func(ctx context.Context, tang *appsv1.YellowTang) error
+
+// 步骤还没有完成，保持当前步骤，稍后重新入队
+type switchoverPendingError struct {
+	message string
+}
+
+func (e *switchoverPendingError) Error() string {
+	return e.message
+}
+
+// 写入中断上限
+func switchoverTimeout(tang *appsv1.YellowTang) time.Duration {
+	if tang.Spec.Switchover != nil && tang.Spec.Switchover.TimeoutSeconds > 0 {
+		return time.Duration(tang.Spec.Switchover.TimeoutSeconds) * time.Second
+	}
+	return defaultSwitchoverTimeout
+}
+
+// 是否有执行到一半的切换
+func switchoverInProgress(tang *appsv1.YellowTang) bool {
+	status := tang.Status.Switchover
+	return status != nil && status.Phase != appsv1.SwitchoverPhaseCompleted && status.Phase != appsv1.SwitchoverPhaseFailed
+}
+
+// 最近一次处理过的 spec.switchover.targetPod
+func observedSwitchoverTarget(tang *appsv1.YellowTang) string {
+	status := tang.Status.Switchover
+	if status == nil {
+		return ""
+	}
+	if status.Reason == "" || status.Reason == appsv1.SwitchoverReasonRequested {
+		return status.TargetPod
+	}
+	return status.RequestedTargetPod
+}
+
+// spec 中是否有新的切换请求
+// spec.switchover.targetPod 被清空时顺便清掉已经结束的切换记录，这样同一个 pod 可以再切一次
+func switchoverRequested(tang *appsv1.YellowTang) bool {
+	if tang.Spec.Switchover == nil || tang.Spec.Switchover.TargetPod == "" {
+		if tang.Status.Switchover != nil && !switchoverInProgress(tang) {
+			tang.Status.Switchover = nil
+		}
+		return false
+	}
+	return observedSwitchoverTarget(tang) != tang.Spec.Switchover.TargetPod
+}
+
+// 计划内主从切换
+// 1. Fencing: 旧主库设置 super_read_only，停止写入
+// 2. WaitingForTarget: 等目标从库执行完旧主库的所有 GTID，超时则恢复旧主库写入并放弃切换
+// 3. Promoting: 停掉目标从库的复制，关闭只读，master-service 切到目标从库
+// 4. Repointing: 其余从库和旧主库指向新主库，旧主库保持只读成为从库
+// 每一步完成后写回 status，控制器重启后从记录的步骤继续
+func (r *YellowTangReconciler) switchover(ctx context.Context, masterPodName string, tang *appsv1.YellowTang) (ctrl.Result, error) {
+	if !switchoverInProgress(tang) {
+		if err := r.startSwitchover(ctx, masterPodName, tang.Spec.Switchover.TargetPod, appsv1.SwitchoverReasonRequested, tang); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return r.runSwitchover(ctx, tang)
+}
+
+// 从 status.switchover 记录的步骤开始执行切换
+func (r *YellowTangReconciler) runSwitchover(ctx context.Context, tang *appsv1.YellowTang) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if !switchoverInProgress(tang) {
+		return ctrl.Result{}, nil
+	}
+
+	steps := map[appsv1.SwitchoverPhase]struct {
+		run  switchoverStepFunc
+		next appsv1.SwitchoverPhase
+	}{
+		appsv1.SwitchoverPhaseFencing:          {r.fenceOldMaster, appsv1.SwitchoverPhaseWaitingForTarget},
+		appsv1.SwitchoverPhaseWaitingForTarget: {r.waitForSwitchoverTarget, appsv1.SwitchoverPhasePromoting},
+		appsv1.SwitchoverPhasePromoting:        {r.promoteSwitchoverTarget, appsv1.SwitchoverPhaseRepointing},
+		appsv1.SwitchoverPhaseRepointing:       {r.repointAfterSwitchover, appsv1.SwitchoverPhaseCompleted},
+	}
+
+	status := tang.Status.Switchover
+	for switchoverInProgress(tang) {
+		step, ok := steps[status.Phase]
+		if !ok {
+			return ctrl.Result{}, fmt.Errorf("unknown switchover phase %q", status.Phase)
+		}
+		logger.Info("执行主从切换步骤", "步骤", status.Phase, "旧主库", status.OldMaster, "新主库", status.TargetPod)
+
+		if err := step.run(ctx, tang); err != nil {
+			var pending *switchoverPendingError
+			if errors.As(err, &pending) {
+				logger.Info("主从切换步骤还没有完成，稍后重试", "步骤", status.Phase, "原因", pending.message)
+				status.Message = fmt.Sprintf("%s: %s", status.Phase, pending.message)
+				return ctrl.Result{RequeueAfter: switchoverRequeueInterval}, r.updateStatus(ctx, tang)
+			}
+			// 步骤内部已经把切换标记为失败的（例如等待超时），不再重试
+			if !switchoverInProgress(tang) {
+				logger.Error(err, "主从切换失败")
+				return ctrl.Result{}, r.updateStatus(ctx, tang)
+			}
+			status.Message = fmt.Sprintf("%s: %v", status.Phase, err)
+			if statusErr := r.updateStatus(ctx, tang); statusErr != nil {
+				logger.Error(statusErr, "更新集群状态失败")
+			}
+			return ctrl.Result{}, err
+		}
+
+		status.Phase = step.next
+		status.Message = ""
+		if status.Phase == appsv1.SwitchoverPhaseCompleted {
+			now := metav1.Now()
+			status.CompletionTime = &now
+			status.Message = fmt.Sprintf("%s promoted to master", status.TargetPod)
+			if status.FencedTime != nil {
+				status.Message += fmt.Sprintf(", writes were blocked for %s", now.Sub(status.FencedTime.Time).Round(time.Millisecond))
+			}
+			tang.Status.MasterPod = status.TargetPod
+			tang.Status.Phase = appsv1.ClusterPhaseRunning
+			setCondition(tang, appsv1.ConditionMasterAvailable, metav1.ConditionTrue, "SwitchoverCompleted", status.Message)
+		}
+		if err := r.updateStatus(ctx, tang); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	logger.Info("主从切换完成", "新主库", status.TargetPod, "旧主库", status.OldMaster)
+	return ctrl.Result{}, nil
+}
+
+// 检查切换请求，合法时记录开始状态
+func (r *YellowTangReconciler) startSwitchover(ctx context.Context, masterPodName, targetPod string, reason appsv1.SwitchoverReason, tang *appsv1.YellowTang) error {
+	now := metav1.Now()
+	status := &appsv1.SwitchoverStatus{
+		TargetPod:          targetPod,
+		Reason:             reason,
+		RequestedTargetPod: observedSwitchoverTarget(tang),
+		OldMaster:          masterPodName,
+		StartTime:          &now,
+	}
+	if reason == appsv1.SwitchoverReasonRequested {
+		status.RequestedTargetPod = targetPod
+	}
+	tang.Status.Switchover = status
+
+	if status.TargetPod == masterPodName {
+		status.Phase = appsv1.SwitchoverPhaseCompleted
+		status.CompletionTime = &now
+		status.Message = fmt.Sprintf("%s is already the master", masterPodName)
+		return r.updateStatus(ctx, tang)
+	}
+
+	if err := r.checkSwitchoverTarget(ctx, tang); err != nil {
+		status.Phase = appsv1.SwitchoverPhaseFailed
+		status.CompletionTime = &now
+		status.Message = err.Error()
+		return r.updateStatus(ctx, tang)
+	}
+
+	status.Phase = appsv1.SwitchoverPhaseFencing
+	tang.Status.Phase = appsv1.ClusterPhaseSwitchingOver
+	return r.updateStatus(ctx, tang)
+}
+
+// 目标必须是本集群中健康且复制正常的从库
+func (r *YellowTangReconciler) checkSwitchoverTarget(ctx context.Context, tang *appsv1.YellowTang) error {
+	target := tang.Status.Switchover.TargetPod
+	targetPod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: target}, ctx, tang)
+	if err != nil {
+		return fmt.Errorf("target pod %s not found: %v", target, err)
+	}
+	if isDelayedReplica(target, tang) {
+		return fmt.Errorf("target pod %s is a delayed replica", target)
+	}
+	if !metav1.IsControlledBy(targetPod, tang) || targetPod.Labels[LabelCluster] != tang.Name || targetPod.Labels["role"] != "slave" {
+		return fmt.Errorf("target pod %s is not a replica of this cluster", target)
+	}
+	if !isPodHealthy(*targetPod) {
+		return fmt.Errorf("target pod %s is not ready", target)
+	}
+
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return err
+	}
+	replica, err := r.getReplicaStatus(ctx, targetPod, creds)
+	if err != nil {
+		return fmt.Errorf("failed to check replication on %s: %v", target, err)
+	}
+	if replica == nil || !replica.IOThreadRunning || !replica.SQLThreadRunning {
+		return fmt.Errorf("replication on target pod %s is not running", target)
+	}
+	return nil
+}
+
+// 把切换标记为失败
+func failSwitchover(tang *appsv1.YellowTang, err error) error {
+	now := metav1.Now()
+	status := tang.Status.Switchover
+	status.Message = fmt.Sprintf("%s: %v", status.Phase, err)
+	status.Phase = appsv1.SwitchoverPhaseFailed
+	status.CompletionTime = &now
+	tang.Status.Phase = appsv1.ClusterPhaseRunning
+	return err
+}
+
+// 按名字获取 pod 和当前生效的账号密码
+func (r *YellowTangReconciler) getPodAndCredentials(name string, ctx context.Context, tang *appsv1.YellowTang) (*corev1.Pod, *mysqlCredentials, error) {
+	pod, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: name}, ctx, tang)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get pod %s: %v", name, err)
+	}
+	creds, err := r.getCredentials(ctx, tang)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pod, creds, nil
+}
+
+// 旧主库停止写入，super_read_only 同时会打开 read_only
+func (r *YellowTangReconciler) fenceOldMaster(ctx context.Context, tang *appsv1.YellowTang) error {
+	status := tang.Status.Switchover
+	oldMaster, creds, err := r.getPodAndCredentials(status.OldMaster, ctx, tang)
+	if err != nil {
+		return err
+	}
+	// 旧主库连不上时放弃切换，交给故障切换处理
+	if err := r.execSQL(ctx, oldMaster, creds, "SET GLOBAL super_read_only = ON"); err != nil {
+		return failSwitchover(tang, err)
+	}
+	if status.FencedTime == nil {
+		now := metav1.Now()
+		status.FencedTime = &now
+	}
+	return nil
+}
+
+// 旧主库恢复写入
+func (r *YellowTangReconciler) unfenceOldMaster(ctx context.Context, tang *appsv1.YellowTang) error {
+	oldMaster, creds, err := r.getPodAndCredentials(tang.Status.Switchover.OldMaster, ctx, tang)
+	if err != nil {
+		return err
+	}
+	return r.execSQL(ctx, oldMaster, creds, "SET GLOBAL super_read_only = OFF", "SET GLOBAL read_only = OFF")
+}
+
+// 等目标从库执行完旧主库的所有事务，写入中断不超过 spec.switchover.timeoutSeconds
+func (r *YellowTangReconciler) waitForSwitchoverTarget(ctx context.Context, tang *appsv1.YellowTang) error {
+	logger := log.FromContext(ctx)
+	status := tang.Status.Switchover
+
+	abort := func(err error) error {
+		if unfenceErr := r.unfenceOldMaster(ctx, tang); unfenceErr != nil {
+			// 旧主库没能恢复写入，保持当前步骤，下次调谐重试
+			return fmt.Errorf("%v, and failed to make %s writable again: %v", err, status.OldMaster, unfenceErr)
+		}
+		logger.Info("放弃主从切换，旧主库已恢复写入", "旧主库", status.OldMaster, "原因", err.Error())
+		return failSwitchover(tang, err)
+	}
+
+	oldMaster, creds, err := r.getPodAndCredentials(status.OldMaster, ctx, tang)
+	if err != nil {
+		return err
+	}
+	masterGTID, err := mysql.GetExecutedGTIDSet(ctx, r.SQL, rootTarget(oldMaster, creds.RootPassword))
+	if err != nil {
+		return err
+	}
+
+	targetPod, _, err := r.getPodAndCredentials(status.TargetPod, ctx, tang)
+	if err != nil {
+		return abort(err)
+	}
+
+	// 控制器重启后从停写时间开始算，不会重新计时
+	remaining := switchoverTimeout(tang)
+	if status.FencedTime != nil {
+		remaining -= time.Since(status.FencedTime.Time)
+	}
+	if remaining < time.Second {
+		remaining = time.Second
+	}
+
+	caughtUp, err := mysql.WaitForExecutedGTIDSet(ctx, r.SQL, rootTarget(targetPod, creds.RootPassword), masterGTID, remaining)
+	if err != nil {
+		return abort(fmt.Errorf("failed to wait for %s: %v", status.TargetPod, err))
+	}
+	if !caughtUp {
+		return abort(fmt.Errorf("%s did not apply all transactions from %s within %s", status.TargetPod, status.OldMaster, switchoverTimeout(tang)))
+	}
+	return nil
+}
+
+// 提升目标从库：停掉复制、关闭只读，并把 master-service 切过去
+func (r *YellowTangReconciler) promoteSwitchoverTarget(ctx context.Context, tang *appsv1.YellowTang) error {
+	status := tang.Status.Switchover
+	targetPod, creds, err := r.getPodAndCredentials(status.TargetPod, ctx, tang)
+	if err != nil {
+		return err
+	}
+	if err := r.execSQL(ctx, targetPod, creds,
+		"STOP SLAVE",
+		"RESET SLAVE ALL",
+		"SET GLOBAL super_read_only = OFF",
+		"SET GLOBAL read_only = OFF",
+	); err != nil {
+		return err
+	}
+
+	// 先把旧主库摘掉，保证 master-service 不会同时指向两个可写的库
+	oldMaster, err := r.getPod(client.ObjectKey{Namespace: tang.Namespace, Name: status.OldMaster}, ctx, tang)
+	if err != nil {
+		return err
+	}
+	if err := r.labelPod(oldMaster, slaveRole(oldMaster.Name, tang), ctx, tang); err != nil {
+		return err
+	}
+	if err := r.labelPod(targetPod, "master", ctx, tang); err != nil {
+		return err
+	}
+	return r.waitMasterEndpoint(ctx, status.TargetPod, tang)
+}
+
+// 确认 master-service 的 endpoint 已经指向新主库，避免从库通过 service 连回旧主库
+// 还没有指向时停留在 Promoting，重新入队后再检查，上面的语句和标签都可以重复执行
+func (r *YellowTangReconciler) waitMasterEndpoint(ctx context.Context, masterPodName string, tang *appsv1.YellowTang) error {
+	alive, current, err := r.checkMasterStatus(ctx, tang)
+	if err != nil {
+		return err
+	}
+	if !alive || current != masterPodName {
+		return &switchoverPendingError{message: fmt.Sprintf("waiting for %s to point to %s", masterServiceName(tang), masterPodName)}
+	}
+	return nil
+}
+
+// 其余从库和旧主库指向新主库
+// 被隔离、正在克隆和正在缩容的 pod 和 checkSlaveStatus 一样跳过，由各自的流程处理
+func (r *YellowTangReconciler) repointAfterSwitchover(ctx context.Context, tang *appsv1.YellowTang) error {
+	status := tang.Status.Switchover
+	pods, err := r.getPodByLabels(clusterLabels(tang), ctx, tang)
+	if err != nil {
+		return err
+	}
+
+	slaveNames := []string{}
+	for _, pod := range pods {
+		if pod.Name != status.TargetPod && !isFencedPod(&pod, tang) && !cloneInProgress(tang, pod.Name) && pod.Labels["role"] != roleRemoving {
+			slaveNames = append(slaveNames, pod.Name)
+		}
+	}
+	return r.setupMasterSlaveReplication(ctx, status.TargetPod, slaveNames, tang)
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "yellowtang/api/v1"
+)
+
+// pod 中 mysql 容器的镜像
+func podImage(pod *corev1.Pod) string {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == "mysql" {
+			return container.Image
+		}
+	}
+	return ""
+}
+
+// 镜像和 spec.image 不一致、需要重建的 pod
+// 被隔离的 pod 不参与升级，重新加入集群后再重建
+func outdatedImagePods(pods []corev1.Pod, tang *appsv1.YellowTang) []string {
+	outdated := []string{}
+	for i := range pods {
+		if podImage(&pods[i]) != tang.Spec.Image && !isFencedPod(&pods[i], tang) {
+			outdated = append(outdated, pods[i].Name)
+		}
+	}
+	sort.Strings(outdated)
+	return outdated
+}
+
+// 根据还没有升级的 pod 和这次滚动重启的结果更新 status.upgrade
+func (r *YellowTangReconciler) recordUpgradeProgress(outdated []string, step rolloutStep, tang *appsv1.YellowTang) {
+	upgrade := tang.Status.Upgrade
+	now := metav1.Now()
+
+	if len(outdated) == 0 {
+		if upgrade != nil && upgrade.Phase != appsv1.UpgradePhaseCompleted {
+			upgrade.Image = tang.Spec.Image
+			upgrade.Phase = appsv1.UpgradePhaseCompleted
+			upgrade.PendingPods = nil
+			upgrade.Message = "all pods are running " + tang.Spec.Image
+			upgrade.CompletionTime = &now
+			r.Recorder.Eventf(tang, corev1.EventTypeNormal, "UpgradeCompleted", "all pods are running %s", tang.Spec.Image)
+		}
+		return
+	}
+
+	if upgrade == nil || upgrade.Image != tang.Spec.Image || upgrade.Phase == appsv1.UpgradePhaseCompleted {
+		upgrade = &appsv1.UpgradeStatus{
+			Image:     tang.Spec.Image,
+			Phase:     appsv1.UpgradePhaseUpgrading,
+			StartTime: now,
+		}
+		tang.Status.Upgrade = upgrade
+		r.Recorder.Eventf(tang, corev1.EventTypeNormal, "UpgradeStarted", "upgrading %d pods to %s", len(outdated), tang.Spec.Image)
+	}
+
+	upgrade.PendingPods = outdated
+	if step.Message != "" {
+		upgrade.Message = step.Message
+	}
+	if step.Paused {
+		if upgrade.Phase != appsv1.UpgradePhasePaused {
+			r.Recorder.Eventf(tang, corev1.EventTypeWarning, "UpgradePaused", "upgrade to %s paused: %s", tang.Spec.Image, step.Message)
+		}
+		upgrade.Phase = appsv1.UpgradePhasePaused
+		return
+	}
+	upgrade.Phase = appsv1.UpgradePhaseUpgrading
+}
@@ -18,25 +18,42 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	appsv1 "yellowtang/api/v1"
+	"yellowtang/internal/mysql"
 )
 
 const (
 	MysqlClusterKind       = "YellowTang"
 	MysqlClusterAPIVersion = "apps.kaxonliu.com/v1"
-	MySQLPassword          = "password"           // Hardcoded MySQL password
-	KubeConfigPath         = "/root/.kube/config" // Hardcoded kubeconfig path
+
+	// 通过 TCP 直连 pod IP 执行 sql
+	SQLBackendTCP = "tcp"
+	// exec 进入 pod 执行 mysql 命令行
+	SQLBackendExec = "exec"
+
+	// 集群正常时定期重新检测的间隔，YellowTang 只有 spec 和 annotation 变化才触发调谐
+	clusterCheckInterval = 30 * time.Second
 )
 
 // YellowTangReconciler reconciles a YellowTang object
@@ -44,13 +61,31 @@ type YellowTangReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	Log    logr.Logger // 日志记录器
-    MasterGTIDSnapshot string  // 用于存储主库的 GTID 快照
-    SnapGoIsEnabled    bool   // 标识用于记录GTID快照的协程序是否启动，默认值为false，只有启动后才会设置为true
+	// 对 MySQL 执行 sql 的方式，为空时在 SetupWithManager 中按 SQLBackend 创建
+	SQL mysql.SQLExecutor
+	// SQLBackendTCP 或 SQLBackendExec，默认 TCP 直连
+	SQLBackend string
+	// 记录 errant 事务、只读修复等事件，为空时在 SetupWithManager 中从 manager 获取
+	Recorder record.EventRecorder
+	// 在 SetupWithManager 时从 manager 获取，所有 pod exec 共用
+	restConfig *rest.Config
+	kubeClient kubernetes.Interface
+	// 不经过缓存直接读 apiserver，写 status 时用来取 merge patch 的基准
+	apiReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=apps.kaxonliu.com,resources=yellowtangs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps.kaxonliu.com,resources=yellowtangs/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=apps.kaxonliu.com,resources=yellowtangs/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups="",resources=endpoints,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -72,39 +107,62 @@ func (r *YellowTangReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// 兼容旧版本：以前通过 annotation "initialized" 标记初始化完成
+	// 旧版本的 pod、PVC 和 ConfigMap 名字为 mysql-NN，先接管它们，不能按新名字重新创建
+	if _, ok := tang.Annotations["initialized"]; ok && tang.Status.InitStep == "" {
+		if err := r.adoptLegacyCluster(ctx, &tang); err != nil {
+			return ctrl.Result{}, err
+		}
+		tang.Status.InitStep = appsv1.InitStepDone
+	}
+
 	// 检查集群是否完成初始化
 	// 初始化完成则进入检测逻辑
-	// 未完成初始化则开始初始化集群
-	if _, ok := tang.Annotations["initialized"]; !ok {
+	// 未完成初始化则从 status.initStep 记录的步骤继续初始化
+	if tang.Status.InitStep != appsv1.InitStepDone {
+		tang.Status.Phase = appsv1.ClusterPhaseInitializing
+		setCondition(&tang, appsv1.ConditionInitialized, metav1.ConditionFalse, "Initializing", fmt.Sprintf("running init step %s", tang.Status.InitStep))
+
 		// 初始化集群
-		if err := r.init(ctx, &tang); err == nil {
-			logger.Info("集群初始化成功")
-		} else {
+		result, err := r.init(ctx, &tang)
+		if err != nil {
 			logger.Error(err, "集群初始化失败")
+			setCondition(&tang, appsv1.ConditionInitialized, metav1.ConditionFalse, "InitFailed", err.Error())
+			if statusErr := r.updateStatus(ctx, &tang); statusErr != nil {
+				logger.Error(statusErr, "更新集群状态失败")
+			}
 			return ctrl.Result{}, err
 		}
-
-		// 标记集群完成初始化
-		if tang.Annotations == nil {
-			tang.Annotations = map[string]string{}
+		if tang.Status.InitStep != appsv1.InitStepDone {
+			if err := r.updateStatus(ctx, &tang); err != nil {
+				return ctrl.Result{}, err
+			}
+			return result, nil
 		}
 
-		tang.Annotations["initialized"] = "true"
-		if err := r.Update(ctx, &tang); err != nil {
+		logger.Info("集群初始化成功")
+		tang.Status.Phase = appsv1.ClusterPhaseRunning
+		setCondition(&tang, appsv1.ConditionInitialized, metav1.ConditionTrue, "Initialized", "cluster bootstrapped")
+		if err := r.updateStatus(ctx, &tang); err != nil {
 			return ctrl.Result{}, err
 		}
 
 	} else {
 		// 检测副本数
-		if result, err := r.checkReplicas(ctx, &tang); err != nil {
-			return result, err
+		replicasResult, err := r.checkReplicas(ctx, &tang)
+		if err != nil {
+			return replicasResult, err
 		}
 
 		//检测主从状态
-		if result, err := r.checkCluster(ctx, &tang); err != nil {
-			return result, err
+		clusterResult, err := r.checkCluster(ctx, &tang)
+		if err != nil {
+			return clusterResult, err
 		}
 
+		// 主库挂掉、复制中断不会产生事件，定期重新检测
+		result := mergeResult(replicasResult, clusterResult)
+		return mergeResult(result, ctrl.Result{RequeueAfter: clusterCheckInterval}), nil
 	}
 
 	return ctrl.Result{}, nil
@@ -112,11 +170,53 @@ func (r *YellowTangReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *YellowTangReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// pod exec 使用 manager 的 rest.Config，只创建一次 clientset
+	r.restConfig = mgr.GetConfig()
+	kubeClient, err := kubernetes.NewForConfig(r.restConfig)
+	if err != nil {
+		return err
+	}
+	r.kubeClient = kubeClient
+	r.apiReader = mgr.GetAPIReader()
+
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("yellowtang-controller")
+	}
+
+	if r.SQL == nil {
+		switch r.SQLBackend {
+		case "", SQLBackendTCP:
+			r.SQL = mysql.NewTCPExecutor(mysql.DefaultTCPOptions())
+		case SQLBackendExec:
+			r.SQL = mysql.NewExecExecutor(r.podExec)
+		default:
+			return fmt.Errorf("unknown sql backend %q", r.SQLBackend)
+		}
+	}
+	// manager 退出时释放连接池
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return r.SQL.Close()
+	})); err != nil {
+		return err
+	}
+
+	// 后台采样每个集群主库的 GTID，供主库挂掉后选主使用
+	if err := mgr.Add(&gtidSnapshotCollector{r: r, interval: gtidSnapshotInterval}); err != nil {
+		return err
+	}
+
 	// 增加：Owns(&v1.Pod{})
 	// 确保 pod 资源发生变动时触发调谐函数的执行
+	// YellowTang 只在 spec 或 annotation 变化时触发，写 status（包括每 10 秒的 gtid 快照）不会触发调谐
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&appsv1.YellowTang{}).
+		For(&appsv1.YellowTang{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
 		Owns(&corev1.Pod{}).
+		// 账号密码 Secret 变化时触发轮换，用户提供的 Secret 没有 ownerReference，所以不能用 Owns
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.findYellowTangsForSecret)).
 		WithOptions(controller.Options{
 			// 增加重试次数
 			MaxConcurrentReconciles: 1,
@@ -126,3 +226,21 @@ func (r *YellowTangReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		}).
 		Complete(r)
 }
+
+// 找到引用该 Secret 作为账号密码的 YellowTang
+func (r *YellowTangReconciler) findYellowTangsForSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	tangList := appsv1.YellowTangList{}
+	if err := r.List(ctx, &tangList, client.InNamespace(secret.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, tang := range tangList.Items {
+		if credentialsSecretName(&tang) == secret.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: client.ObjectKeyFromObject(&tang),
+			})
+		}
+	}
+	return requests
+}
@@ -18,11 +18,17 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -81,4 +87,238 @@ var _ = Describe("YellowTang Controller", func() {
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
 	})
+
+	Context("When two clusters share a namespace", func() {
+		const namespace = "default"
+		clusterNames := []string{"tang-a", "tang-b"}
+
+		ctx := context.Background()
+
+		newCluster := func(name string) *appsv1.YellowTang {
+			return &appsv1.YellowTang{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: appsv1.YellowTangSpec{
+					Image:    "mysql:5.7",
+					Replicas: 2,
+					Storage: appsv1.StorageConfig{
+						StorageClassName: "standard",
+						Size:             "1Gi",
+					},
+					Resources: appsv1.ResourcesConfig{
+						Requests: appsv1.BaseResource{CPU: "100m", Memory: "128Mi"},
+						Limits:   appsv1.BaseResource{CPU: "500m", Memory: "512Mi"},
+					},
+					Clone: appsv1.CloneSpec{Method: appsv1.CloneMethodNone},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			By("creating two YellowTangs in the same namespace")
+			for _, name := range clusterNames {
+				Expect(k8sClient.Create(ctx, newCluster(name))).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			By("cleaning up both clusters and the pods they created")
+			for _, name := range clusterNames {
+				tang := &appsv1.YellowTang{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, tang)).To(Succeed())
+				Expect(k8sClient.Delete(ctx, tang)).To(Succeed())
+			}
+			// envtest 中没有垃圾回收，手动删除 pod
+			Expect(k8sClient.DeleteAllOf(ctx, &corev1.Pod{}, client.InNamespace(namespace), client.MatchingLabels{"app": "mysql"})).To(Succeed())
+		})
+
+		It("should keep the pods and services of each cluster apart", func() {
+			controllerReconciler := &YellowTangReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+
+			By("reconciling both clusters")
+			for _, name := range clusterNames {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: name, Namespace: namespace},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			for _, name := range clusterNames {
+				tang := &appsv1.YellowTang{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, tang)).To(Succeed())
+
+				By("listing only the pods of " + name)
+				pods, err := controllerReconciler.getAllPodByLabels(clusterLabels(tang), ctx, tang)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pods).To(HaveLen(2))
+				for i := range pods {
+					Expect(strings.HasPrefix(pods[i].Name, name+"-mysql-")).To(BeTrue())
+					Expect(pods[i].Labels).To(HaveKeyWithValue(LabelCluster, name))
+					Expect(metav1.IsControlledBy(&pods[i], tang)).To(BeTrue())
+				}
+
+				By("selecting only the pods of " + name + " from its services")
+				for _, serviceName := range []string{masterServiceName(tang), slaveServiceName(tang), headlessServiceName(tang)} {
+					service := &corev1.Service{}
+					Expect(k8sClient.Get(ctx, types.NamespacedName{Name: serviceName, Namespace: namespace}, service)).To(Succeed())
+					Expect(service.Spec.Selector).To(HaveKeyWithValue(LabelCluster, name))
+					Expect(metav1.IsControlledBy(service, tang)).To(BeTrue())
+				}
+			}
+
+			By("ignoring a pod that carries the labels of tang-a but is owned by tang-b")
+			tangA := &appsv1.YellowTang{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "tang-a", Namespace: namespace}, tangA)).To(Succeed())
+			tangB := &appsv1.YellowTang{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "tang-b", Namespace: namespace}, tangB)).To(Succeed())
+
+			intruder := buildPod("tang-a-mysql-9", "tang-b-mysql-0", "tang-b-mysql-0", nil, tangB)
+			intruder.Labels = clusterLabels(tangA)
+			Expect(k8sClient.Create(ctx, &intruder)).To(Succeed())
+
+			pods, err := controllerReconciler.getAllPodByLabels(clusterLabels(tangA), ctx, tangA)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pods).To(HaveLen(2))
+			for i := range pods {
+				Expect(pods[i].Name).NotTo(Equal(intruder.Name))
+			}
+		})
+	})
+
+	Context("When upgrading a cluster created by the previous version", func() {
+		const namespace = "default"
+		const name = "legacy"
+
+		ctx := context.Background()
+		legacyNames := []string{"mysql-01", "mysql-02"}
+
+		BeforeEach(func() {
+			By("creating a YellowTang and the mysql-NN objects the previous version left behind")
+			tang := &appsv1.YellowTang{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Namespace:   namespace,
+					Annotations: map[string]string{"initialized": "true"},
+				},
+				Spec: appsv1.YellowTangSpec{
+					Image:             "mysql:5.7",
+					Replicas:          2,
+					MasterServiceName: "master-service",
+					SlaveServiceName:  "slave-service",
+					Storage: appsv1.StorageConfig{
+						StorageClassName: "standard",
+						Size:             "1Gi",
+					},
+					Resources: appsv1.ResourcesConfig{
+						Requests: appsv1.BaseResource{CPU: "100m", Memory: "128Mi"},
+						Limits:   appsv1.BaseResource{CPU: "500m", Memory: "512Mi"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, tang)).To(Succeed())
+
+			isController := true
+			for i, legacyName := range legacyNames {
+				meta := func() metav1.ObjectMeta {
+					return metav1.ObjectMeta{
+						Name:      legacyName,
+						Namespace: namespace,
+						Labels:    map[string]string{"tang": "true", "app": "mysql"},
+						OwnerReferences: []metav1.OwnerReference{{
+							APIVersion: MysqlClusterAPIVersion,
+							Kind:       MysqlClusterKind,
+							Name:       tang.Name,
+							UID:        tang.UID,
+							Controller: &isController,
+						}},
+					}
+				}
+				pvc := &corev1.PersistentVolumeClaim{
+					ObjectMeta: meta(),
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+						Resources: corev1.VolumeResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+						},
+					},
+				}
+				Expect(k8sClient.Create(ctx, pvc)).To(Succeed())
+				cm := &corev1.ConfigMap{
+					ObjectMeta: meta(),
+					Data:       map[string]string{"my.cnf": fmt.Sprintf("[mysqld]\nserver-id=%d\n", i+1)},
+				}
+				Expect(k8sClient.Create(ctx, cm)).To(Succeed())
+				pod := &corev1.Pod{
+					ObjectMeta: meta(),
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "mysql", Image: "mysql:5.7"}},
+					},
+				}
+				pod.Labels["role"] = "slave"
+				if i == 0 {
+					pod.Labels["role"] = "master"
+				}
+				Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			By("cleaning up the cluster and the legacy objects")
+			tang := &appsv1.YellowTang{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, tang)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, tang)).To(Succeed())
+			// envtest 中没有垃圾回收，手动删除
+			for _, object := range []client.Object{&corev1.Pod{}, &corev1.PersistentVolumeClaim{}, &corev1.ConfigMap{}} {
+				Expect(k8sClient.DeleteAllOf(ctx, object, client.InNamespace(namespace), client.MatchingLabels{"app": "mysql"})).To(Succeed())
+			}
+		})
+
+		It("should keep the mysql-NN pods and their data instead of creating new ones", func() {
+			controllerReconciler := &YellowTangReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			tang := &appsv1.YellowTang{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, tang)).To(Succeed())
+
+			By("adopting the legacy objects the way Reconcile does on the first run after the upgrade")
+			Expect(controllerReconciler.adoptLegacyCluster(ctx, tang)).To(Succeed())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, tang)).To(Succeed())
+			Expect(tang.Annotations).To(HaveKeyWithValue(AnnotationLegacyPodNames, "true"))
+			Expect(mysqlPodName(tang, 0)).To(Equal("mysql-01"))
+
+			for _, legacyName := range legacyNames {
+				for _, object := range []client.Object{&corev1.Pod{}, &corev1.PersistentVolumeClaim{}, &corev1.ConfigMap{}} {
+					Expect(k8sClient.Get(ctx, types.NamespacedName{Name: legacyName, Namespace: namespace}, object)).To(Succeed())
+					Expect(object.GetLabels()).To(HaveKeyWithValue(LabelCluster, name))
+					Expect(object.GetLabels()).To(HaveKeyWithValue("tang", "true"))
+				}
+			}
+
+			By("selecting the legacy pods by the cluster labels")
+			pods, err := controllerReconciler.getAllPodByLabels(clusterLabels(tang), ctx, tang)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pods).To(HaveLen(2))
+			for i := range pods {
+				Expect(legacyNames).To(ContainElement(pods[i].Name))
+			}
+
+			By("not creating pods or PVCs under the new names")
+			_, err = controllerReconciler.checkReplicas(ctx, tang)
+			Expect(err).NotTo(HaveOccurred())
+			podList := &corev1.PodList{}
+			Expect(k8sClient.List(ctx, podList, client.InNamespace(namespace), client.MatchingLabels{LabelCluster: name})).To(Succeed())
+			Expect(podList.Items).To(HaveLen(2))
+			pvc := &corev1.PersistentVolumeClaim{}
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: name + "-mysql-0", Namespace: namespace}, pvc)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
 })
@@ -0,0 +1,258 @@
+// Package gtid 实现 MySQL GTID 集合的解析和集合运算
+// 格式为 uuid:1-5:7-9，多个 uuid 之间用逗号分隔，例如 SHOW MASTER STATUS 的 Executed_Gtid_Set
+package gtid
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 一段连续的事务编号，闭区间 [Start, End]
+type Interval struct {
+	Start int64
+	End   int64
+}
+
+// 事务个数
+func (i Interval) Count() int64 {
+	return i.End - i.Start + 1
+}
+
+// Set 是 GTID 集合，server uuid 到有序、不重叠、不相邻的区间列表的映射
+// 零值（nil）表示空集合，所有运算都不会修改接收者和参数
+type Set map[string][]Interval
+
+// Parse 解析 GTID 集合，空字符串返回空集合
+func Parse(s string) (Set, error) {
+	set := Set{}
+	// 命令行输出的集合可能带有换行
+	s = strings.Join(strings.Fields(s), "")
+	if s == "" {
+		return set, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		uuid := strings.ToLower(fields[0])
+		if !validUUID(uuid) {
+			return nil, fmt.Errorf("invalid gtid set %q: bad uuid %q", s, fields[0])
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid gtid set %q: %s has no intervals", s, uuid)
+		}
+		for _, field := range fields[1:] {
+			interval, err := parseInterval(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid gtid set %q: %v", s, err)
+			}
+			set[uuid] = append(set[uuid], interval)
+		}
+	}
+
+	for uuid, intervals := range set {
+		set[uuid] = normalize(intervals)
+	}
+	return set, nil
+}
+
+// 解析 1-5 或 7 这样的区间
+func parseInterval(s string) (Interval, error) {
+	start, end, isRange := strings.Cut(s, "-")
+	first, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || first < 1 {
+		return Interval{}, fmt.Errorf("bad interval %q", s)
+	}
+	if !isRange {
+		return Interval{Start: first, End: first}, nil
+	}
+	last, err := strconv.ParseInt(end, 10, 64)
+	if err != nil || last < first {
+		return Interval{}, fmt.Errorf("bad interval %q", s)
+	}
+	return Interval{Start: first, End: last}, nil
+}
+
+// 检查 8-4-4-4-12 格式的 uuid
+func validUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 排序并合并重叠或相邻的区间
+func normalize(intervals []Interval) []Interval {
+	if len(intervals) == 0 {
+		return nil
+	}
+	sorted := append([]Interval(nil), intervals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	merged := []Interval{sorted[0]}
+	for _, interval := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if interval.Start <= last.End+1 {
+			if interval.End > last.End {
+				last.End = interval.End
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+	return merged
+}
+
+// String 返回 MySQL 的格式，uuid 按字典序排列
+func (s Set) String() string {
+	uuids := s.uuids()
+	parts := make([]string, 0, len(uuids))
+	for _, uuid := range uuids {
+		var b strings.Builder
+		b.WriteString(uuid)
+		for _, interval := range s[uuid] {
+			b.WriteString(":")
+			b.WriteString(strconv.FormatInt(interval.Start, 10))
+			if interval.End != interval.Start {
+				b.WriteString("-")
+				b.WriteString(strconv.FormatInt(interval.End, 10))
+			}
+		}
+		parts = append(parts, b.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s Set) uuids() []string {
+	uuids := make([]string, 0, len(s))
+	for uuid, intervals := range s {
+		if len(intervals) > 0 {
+			uuids = append(uuids, uuid)
+		}
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+// GTIDs 按顺序列出集合中的每一个事务，例如 uuid:1、uuid:2
+// 集合可能很大，调用前先用 Count 判断
+func (s Set) GTIDs() []string {
+	gtids := make([]string, 0, s.Count())
+	for _, uuid := range s.uuids() {
+		for _, interval := range s[uuid] {
+			for n := interval.Start; n <= interval.End; n++ {
+				gtids = append(gtids, uuid+":"+strconv.FormatInt(n, 10))
+			}
+		}
+	}
+	return gtids
+}
+
+// IsEmpty 判断集合是否为空
+func (s Set) IsEmpty() bool {
+	for _, intervals := range s {
+		if len(intervals) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Count 返回集合中的事务个数
+func (s Set) Count() int64 {
+	var count int64
+	for _, intervals := range s {
+		for _, interval := range intervals {
+			count += interval.Count()
+		}
+	}
+	return count
+}
+
+// Union 返回 s 和 other 的并集
+func (s Set) Union(other Set) Set {
+	result := Set{}
+	for uuid, intervals := range s {
+		result[uuid] = append(result[uuid], intervals...)
+	}
+	for uuid, intervals := range other {
+		result[uuid] = append(result[uuid], intervals...)
+	}
+	for uuid, intervals := range result {
+		if intervals = normalize(intervals); len(intervals) == 0 {
+			delete(result, uuid)
+		} else {
+			result[uuid] = intervals
+		}
+	}
+	return result
+}
+
+// Subtract 返回在 s 中但不在 other 中的事务
+func (s Set) Subtract(other Set) Set {
+	result := Set{}
+	for uuid, intervals := range s {
+		if remaining := subtractIntervals(normalize(intervals), normalize(other[uuid])); len(remaining) > 0 {
+			result[uuid] = remaining
+		}
+	}
+	return result
+}
+
+// 两个有序区间列表相减
+func subtractIntervals(a, b []Interval) []Interval {
+	var result []Interval
+	j := 0
+	for _, interval := range a {
+		start := interval.Start
+		for j < len(b) && b[j].End < start {
+			j++
+		}
+		for k := j; k < len(b) && b[k].Start <= interval.End; k++ {
+			if b[k].Start > start {
+				result = append(result, Interval{Start: start, End: b[k].Start - 1})
+			}
+			start = b[k].End + 1
+		}
+		if start <= interval.End {
+			result = append(result, Interval{Start: start, End: interval.End})
+		}
+	}
+	return result
+}
+
+// Intersect 返回 s 和 other 的交集
+func (s Set) Intersect(other Set) Set {
+	return s.Subtract(s.Subtract(other))
+}
+
+// Contains 判断 other 是否是 s 的子集
+func (s Set) Contains(other Set) bool {
+	return other.Subtract(s).IsEmpty()
+}
+
+// IsSubsetOf 判断 s 是否是 other 的子集
+func (s Set) IsSubsetOf(other Set) bool {
+	return other.Contains(s)
+}
+
+// Equal 判断两个集合是否包含相同的事务
+func (s Set) Equal(other Set) bool {
+	return s.Contains(other) && other.Contains(s)
+}
@@ -0,0 +1,228 @@
+package gtid
+
+import (
+	"testing"
+)
+
+const (
+	uuidA = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	uuidB = "8a94f357-aab4-11df-86ab-c80aa9429563"
+)
+
+func mustParse(t *testing.T, s string) Set {
+	t.Helper()
+	set, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", s, err)
+	}
+	return set
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"whitespace only", " \n", ""},
+		{"single transaction", uuidA + ":5", uuidA + ":5"},
+		{"single interval", uuidA + ":1-5", uuidA + ":1-5"},
+		{"multiple intervals", uuidA + ":1-5:7-9", uuidA + ":1-5:7-9"},
+		{"unsorted intervals", uuidA + ":7-9:1-5", uuidA + ":1-5:7-9"},
+		{"overlapping intervals", uuidA + ":1-5:3-8", uuidA + ":1-8"},
+		{"adjacent intervals", uuidA + ":1-5:6-9", uuidA + ":1-9"},
+		{"contained interval", uuidA + ":1-10:3-4", uuidA + ":1-10"},
+		{"multiple uuids sorted", uuidB + ":1-3," + uuidA + ":1-2", uuidA + ":1-2," + uuidB + ":1-3"},
+		{"repeated uuid", uuidA + ":1-2," + uuidA + ":3-4", uuidA + ":1-4"},
+		{"uppercase uuid", "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-2", uuidA + ":1-2"},
+		{"newline from mysql output", uuidA + ":1-5,\n" + uuidB + ":1", uuidA + ":1-5," + uuidB + ":1"},
+		{"trailing comma", uuidA + ":1-5,", uuidA + ":1-5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mustParse(t, tt.in).String(); got != tt.want {
+				t.Errorf("Parse(%q).String() = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"not-a-uuid:1-5",
+		uuidA,
+		uuidA + ":",
+		uuidA + ":0",
+		uuidA + ":0-5",
+		uuidA + ":5-1",
+		uuidA + ":a-b",
+		uuidA + ":1-",
+		uuidA + ":1-5:",
+		"3e11fa47x71ca-11e1-9e33-c80aa9429562:1",
+		"3e11fa47-71ca-11e1-9e33-c80aa942956g:1",
+	}
+	for _, in := range tests {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", in)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", 0},
+		{uuidA + ":1", 1},
+		{uuidA + ":1-5", 5},
+		{uuidA + ":1-5:7-9", 8},
+		{uuidA + ":1-5," + uuidB + ":1-100", 105},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.in).Count(); got != tt.want {
+			t.Errorf("Parse(%q).Count() = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGTIDs(t *testing.T) {
+	got := mustParse(t, uuidB+":3,"+uuidA+":1-2:5").GTIDs()
+	want := []string{uuidA + ":1", uuidA + ":2", uuidA + ":5", uuidB + ":3"}
+	if len(got) != len(want) {
+		t.Fatalf("GTIDs() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("GTIDs()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	if got := mustParse(t, "").GTIDs(); len(got) != 0 {
+		t.Errorf("GTIDs() of empty set = %v, want none", got)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{uuidA + ":1-5", "", uuidA + ":1-5"},
+		{"", uuidA + ":1-5", uuidA + ":1-5"},
+		{uuidA + ":1-5", uuidA + ":3-9", uuidA + ":1-9"},
+		{uuidA + ":1-5", uuidA + ":6-9", uuidA + ":1-9"},
+		{uuidA + ":1-5", uuidA + ":8-9", uuidA + ":1-5:8-9"},
+		{uuidA + ":1-5", uuidB + ":1-2", uuidA + ":1-5," + uuidB + ":1-2"},
+		{uuidA + ":1-3:7-9", uuidA + ":4-6", uuidA + ":1-9"},
+	}
+	for _, tt := range tests {
+		a, b := mustParse(t, tt.a), mustParse(t, tt.b)
+		if got := a.Union(b).String(); got != tt.want {
+			t.Errorf("%q ∪ %q = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+		// 并集不能修改参数
+		if a.String() != mustParse(t, tt.a).String() || b.String() != mustParse(t, tt.b).String() {
+			t.Errorf("%q ∪ %q modified its operands", tt.a, tt.b)
+		}
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{uuidA + ":1-5", "", uuidA + ":1-5"},
+		{"", uuidA + ":1-5", ""},
+		{uuidA + ":1-5", uuidA + ":1-5", ""},
+		{uuidA + ":1-5", uuidA + ":1-10", ""},
+		{uuidA + ":1-10", uuidA + ":1-5", uuidA + ":6-10"},
+		{uuidA + ":1-10", uuidA + ":6-10", uuidA + ":1-5"},
+		{uuidA + ":1-10", uuidA + ":3-4", uuidA + ":1-2:5-10"},
+		{uuidA + ":1-10", uuidA + ":2:4:6-7", uuidA + ":1:3:5:8-10"},
+		{uuidA + ":1-5:10-15", uuidA + ":4-11", uuidA + ":1-3:12-15"},
+		{uuidA + ":1-5," + uuidB + ":1-5", uuidA + ":1-5", uuidB + ":1-5"},
+		{uuidA + ":1-5", uuidB + ":1-5", uuidA + ":1-5"},
+		{uuidA + ":5-10", uuidA + ":1-2:20-30", uuidA + ":5-10"},
+	}
+	for _, tt := range tests {
+		a, b := mustParse(t, tt.a), mustParse(t, tt.b)
+		if got := a.Subtract(b).String(); got != tt.want {
+			t.Errorf("%q - %q = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", uuidA + ":1-5", ""},
+		{uuidA + ":1-5", uuidA + ":3-9", uuidA + ":3-5"},
+		{uuidA + ":1-5", uuidB + ":1-5", ""},
+		{uuidA + ":1-10," + uuidB + ":1-3", uuidA + ":2:8-12," + uuidB + ":3", uuidA + ":2:8-10," + uuidB + ":3"},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.a).Intersect(mustParse(t, tt.b)).String(); got != tt.want {
+			t.Errorf("%q ∩ %q = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"", "", true},
+		{uuidA + ":1-5", "", true},
+		{"", uuidA + ":1", false},
+		{uuidA + ":1-5", uuidA + ":1-5", true},
+		{uuidA + ":1-5", uuidA + ":2-3", true},
+		{uuidA + ":1-5", uuidA + ":2-6", false},
+		{uuidA + ":1-5:7-9", uuidA + ":1-9", false},
+		{uuidA + ":1-5," + uuidB + ":1-5", uuidB + ":4", true},
+		{uuidA + ":1-5", uuidB + ":1", false},
+	}
+	for _, tt := range tests {
+		a, b := mustParse(t, tt.a), mustParse(t, tt.b)
+		if got := a.Contains(b); got != tt.want {
+			t.Errorf("%q.Contains(%q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := b.IsSubsetOf(a); got != tt.want {
+			t.Errorf("%q.IsSubsetOf(%q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	if !mustParse(t, uuidA+":1-3:4-5").Equal(mustParse(t, uuidA+":1-5")) {
+		t.Errorf("expected normalized sets to be equal")
+	}
+	if mustParse(t, uuidA+":1-5").Equal(mustParse(t, uuidA+":1-4")) {
+		t.Errorf("expected different sets not to be equal")
+	}
+}
+
+func TestNilSet(t *testing.T) {
+	var empty Set
+	set := mustParse(t, uuidA+":1-5")
+
+	if !empty.IsEmpty() || empty.Count() != 0 || empty.String() != "" {
+		t.Errorf("nil set should be empty")
+	}
+	if got := empty.Union(set).String(); got != set.String() {
+		t.Errorf("nil ∪ set = %q, want %q", got, set.String())
+	}
+	if got := set.Subtract(empty).String(); got != set.String() {
+		t.Errorf("set - nil = %q, want %q", got, set.String())
+	}
+	if !set.Contains(empty) {
+		t.Errorf("every set contains the empty set")
+	}
+}
@@ -0,0 +1,93 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 在 pod 内执行 shell 命令并返回标准输出
+type PodExecFunc func(ctx context.Context, pod *corev1.Pod, command string) (string, error)
+
+// ExecExecutor 通过 exec 进入 pod 执行 mysql 命令行，作为 TCP 直连不可用时的后备方案
+type ExecExecutor struct {
+	exec PodExecFunc
+	// 单次 Exec/Query 的超时时间，和 TCP 后端一致
+	queryTimeout time.Duration
+}
+
+var _ SQLExecutor = &ExecExecutor{}
+
+func NewExecExecutor(exec PodExecFunc) *ExecExecutor {
+	return &ExecExecutor{exec: exec, queryTimeout: DefaultTCPOptions().QueryTimeout}
+}
+
+// 给 shell 使用的单引号转义
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// 生成在 pod 内执行 sql 的 shell 命令
+// 密码通过 MYSQL_PWD 传入，不会出现在 mysql 的参数里；--batch 输出以 tab 分隔，第一行是列名
+func mysqlCommand(target Target, sql string) string {
+	return fmt.Sprintf("MYSQL_PWD=%s mysql --batch -u%s -e %s",
+		shellQuote(target.Password), shellQuote(target.User), shellQuote(sql))
+}
+
+func (e *ExecExecutor) Exec(ctx context.Context, target Target, statements ...string) error {
+	if target.Pod == nil {
+		return fmt.Errorf("exec executor requires a pod")
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.queryTimeout)
+	defer cancel()
+	// 同一条 mysql 命令中执行，保证在同一个会话里
+	_, err := e.exec(ctx, target.Pod, mysqlCommand(target, strings.Join(statements, ";\n")+";"))
+	return err
+}
+
+func (e *ExecExecutor) Query(ctx context.Context, target Target, query string) ([]Row, error) {
+	if target.Pod == nil {
+		return nil, fmt.Errorf("exec executor requires a pod")
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.queryTimeout)
+	defer cancel()
+	output, err := e.exec(ctx, target.Pod, mysqlCommand(target, query))
+	if err != nil {
+		return nil, err
+	}
+	return parseBatchOutput(output), nil
+}
+
+func (e *ExecExecutor) Close() error {
+	return nil
+}
+
+// --batch 模式对值中的特殊字符做了转义
+var batchUnescaper = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\0`, "\x00", `\\`, `\`)
+
+// 解析 mysql --batch 的输出
+func parseBatchOutput(output string) []Row {
+	rows := []Row{}
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) == 0 || lines[0] == "" {
+		return rows
+	}
+
+	columns := strings.Split(lines[0], "\t")
+	for _, line := range lines[1:] {
+		values := strings.Split(line, "\t")
+		row := Row{}
+		for i, column := range columns {
+			if i >= len(values) || values[i] == "NULL" {
+				row[column] = ""
+				continue
+			}
+			row[column] = batchUnescaper.Replace(values[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
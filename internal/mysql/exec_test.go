@@ -0,0 +1,111 @@
+package mysql
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseBatchOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Row
+	}{
+		{"empty output", "", []Row{}},
+		{"only newline", "\n", []Row{}},
+		{"columns without rows", "Variable_name\tValue\n", []Row{}},
+		{
+			"single row",
+			"Variable_name\tValue\nread_only\tON\n",
+			[]Row{{"Variable_name": "read_only", "Value": "ON"}},
+		},
+		{
+			"multiple rows without trailing newline",
+			"PLUGIN_NAME\tPLUGIN_STATUS\nclone\tACTIVE\nrpl_semi_sync_master\tDISABLED",
+			[]Row{
+				{"PLUGIN_NAME": "clone", "PLUGIN_STATUS": "ACTIVE"},
+				{"PLUGIN_NAME": "rpl_semi_sync_master", "PLUGIN_STATUS": "DISABLED"},
+			},
+		},
+		{
+			"null becomes empty string",
+			"Master_Host\tSeconds_Behind_Master\ndemo-master\tNULL\n",
+			[]Row{{"Master_Host": "demo-master", "Seconds_Behind_Master": ""}},
+		},
+		{
+			"empty value",
+			"Last_IO_Error\tSlave_IO_Running\n\tYes\n",
+			[]Row{{"Last_IO_Error": "", "Slave_IO_Running": "Yes"}},
+		},
+		{
+			"missing trailing columns",
+			"a\tb\tc\n1\n",
+			[]Row{{"a": "1", "b": "", "c": ""}},
+		},
+		{
+			"escaped newline in gtid set",
+			"Executed_Gtid_Set\n3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,\\n8a94f357-aab4-11df-86ab-c80aa9429563:1\n",
+			[]Row{{"Executed_Gtid_Set": "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,\n8a94f357-aab4-11df-86ab-c80aa9429563:1"}},
+		},
+		{
+			"escaped tab, nul and backslash",
+			"v\na\\tb\\0c\\\\n\n",
+			[]Row{{"v": "a\tb\x00c\\n"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseBatchOutput(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBatchOutput(%q) = %v, want %v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMySQLCommand(t *testing.T) {
+	target := Target{User: "root", Password: "it's"}
+	want := `MYSQL_PWD='it'\''s' mysql --batch -u'root' -e 'SELECT '\''a'\'''`
+	if got := mysqlCommand(target, "SELECT 'a'"); got != want {
+		t.Errorf("mysqlCommand() = %s, want %s", got, want)
+	}
+}
+
+func TestExecExecutorExecJoinsStatements(t *testing.T) {
+	var command string
+	e := NewExecExecutor(func(ctx context.Context, pod *corev1.Pod, cmd string) (string, error) {
+		command = cmd
+		return "", nil
+	})
+	target := Target{Pod: &corev1.Pod{}, User: "root", Password: "pw"}
+	if err := e.Exec(context.Background(), target, "SET SQL_LOG_BIN = 0", "SET SQL_LOG_BIN = 1"); err != nil {
+		t.Fatalf("Exec() = %v", err)
+	}
+	want := mysqlCommand(target, "SET SQL_LOG_BIN = 0;\nSET SQL_LOG_BIN = 1;")
+	if command != want {
+		t.Errorf("command = %s, want %s", command, want)
+	}
+	if err := e.Exec(context.Background(), Target{}, "SELECT 1"); err == nil {
+		t.Errorf("Exec() without pod should fail")
+	}
+}
+
+func TestExecExecutorSetsDeadline(t *testing.T) {
+	var deadline time.Time
+	e := NewExecExecutor(func(ctx context.Context, pod *corev1.Pod, cmd string) (string, error) {
+		deadline, _ = ctx.Deadline()
+		return "", nil
+	})
+	target := Target{Pod: &corev1.Pod{}, User: "root", Password: "pw"}
+	timeout := DefaultTCPOptions().QueryTimeout
+	start := time.Now()
+	if _, err := e.Query(context.Background(), target, "SELECT 1"); err != nil {
+		t.Fatalf("Query() = %v", err)
+	}
+	if deadline.Before(start.Add(timeout)) || deadline.After(time.Now().Add(timeout)) {
+		t.Errorf("deadline = %v, want %v after the query started", deadline, timeout)
+	}
+}
@@ -0,0 +1,62 @@
+// Package mysql 封装了控制器对 MySQL 实例执行 sql 的方式
+// 默认通过 TCP 直连 pod IP，也可以退回到 kubectl exec 进入 pod 执行 mysql 命令
+package mysql
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MySQL 默认端口
+const DefaultPort = 3306
+
+// 执行 sql 的目标实例
+type Target struct {
+	// 实例所在的 pod，exec 后端通过它进入容器
+	Pod *corev1.Pod
+	// TCP 后端连接的地址，为空时使用 pod IP
+	Host string
+	Port int
+	// 登录账号密码
+	User     string
+	Password string
+}
+
+// 目标地址
+func (t Target) host() string {
+	if t.Host != "" {
+		return t.Host
+	}
+	if t.Pod != nil {
+		return t.Pod.Status.PodIP
+	}
+	return ""
+}
+
+func (t Target) port() int {
+	if t.Port != 0 {
+		return t.Port
+	}
+	return DefaultPort
+}
+
+// 查询结果中的一行，列名到值的映射，NULL 被当作空字符串
+type Row map[string]string
+
+// SQLExecutor 对一个 MySQL 实例执行 sql
+type SQLExecutor interface {
+	// 在同一个会话中依次执行多条语句
+	Exec(ctx context.Context, target Target, statements ...string) error
+	// 执行查询并返回所有行
+	Query(ctx context.Context, target Target, query string) ([]Row, error)
+	// 释放连接池等资源
+	Close() error
+}
+
+// QuoteString 把字符串转成 sql 字符串字面量
+func QuoteString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(s) + "'"
+}
@@ -0,0 +1,28 @@
+package mysql
+
+import "testing"
+
+func TestQuoteString(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", `''`},
+		{"plain", "repl", `'repl'`},
+		{"single quote", "it's", `'it\'s'`},
+		{"backslash", `a\b`, `'a\\b'`},
+		{"backslash before quote", `\'`, `'\\\''`},
+		{"trailing backslash", `pass\`, `'pass\\'`},
+		{"injection", "x'; DROP USER root; --", `'x\'; DROP USER root; --'`},
+		{"double quote unchanged", `say "hi"`, `'say "hi"'`},
+		{"gtid set", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5", `'3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QuoteString(tt.in); got != tt.want {
+				t.Errorf("QuoteString(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SHOW SLAVE STATUS 的结果
+type ReplicaStatus struct {
+	MasterHost          string
+	MasterPort          int
+	MasterUser          string
+	IOThreadRunning     bool
+	SQLThreadRunning    bool
+	SecondsBehindMaster *int64
+	RetrievedGtidSet    string
+	ExecutedGtidSet     string
+	LastIOError         string
+	LastSQLError        string
+	// MASTER_DELAY 设置的延迟（秒）
+	SQLDelay int64
+	// START SLAVE UNTIL 的条件，没有时为 None
+	UntilCondition string
+}
+
+// 最近一次 IO/SQL 线程的错误
+func (s *ReplicaStatus) LastError() string {
+	if s.LastIOError != "" {
+		return s.LastIOError
+	}
+	return s.LastSQLError
+}
+
+// SHOW MASTER STATUS 的结果
+type MasterStatus struct {
+	File            string
+	Position        int64
+	ExecutedGtidSet string
+}
+
+// gtid 集合在输出中可能带有换行
+func normalizeGTIDSet(set string) string {
+	return strings.Join(strings.Fields(set), "")
+}
+
+// 查询从库状态，实例没有配置复制时返回 nil
+func GetReplicaStatus(ctx context.Context, e SQLExecutor, target Target) (*ReplicaStatus, error) {
+	rows, err := e.Query(ctx, target, "SHOW SLAVE STATUS")
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	row := rows[0]
+	status := &ReplicaStatus{
+		MasterHost:       row["Master_Host"],
+		MasterUser:       row["Master_User"],
+		IOThreadRunning:  row["Slave_IO_Running"] == "Yes",
+		SQLThreadRunning: row["Slave_SQL_Running"] == "Yes",
+		RetrievedGtidSet: normalizeGTIDSet(row["Retrieved_Gtid_Set"]),
+		ExecutedGtidSet:  normalizeGTIDSet(row["Executed_Gtid_Set"]),
+		LastIOError:      row["Last_IO_Error"],
+		LastSQLError:     row["Last_SQL_Error"],
+		UntilCondition:   row["Until_Condition"],
+	}
+	status.MasterPort, _ = strconv.Atoi(row["Master_Port"])
+	status.SQLDelay, _ = strconv.ParseInt(row["SQL_Delay"], 10, 64)
+	if lag, err := strconv.ParseInt(row["Seconds_Behind_Master"], 10, 64); err == nil {
+		status.SecondsBehindMaster = &lag
+	}
+	return status, nil
+}
+
+// 查询主库状态
+func GetMasterStatus(ctx context.Context, e SQLExecutor, target Target) (*MasterStatus, error) {
+	rows, err := e.Query(ctx, target, "SHOW MASTER STATUS")
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("binary log is not enabled")
+	}
+
+	row := rows[0]
+	position, _ := strconv.ParseInt(row["Position"], 10, 64)
+	return &MasterStatus{
+		File:            row["File"],
+		Position:        position,
+		ExecutedGtidSet: normalizeGTIDSet(row["Executed_Gtid_Set"]),
+	}, nil
+}
+
+// 查询全局变量
+func GetGlobalVariable(ctx context.Context, e SQLExecutor, target Target, name string) (string, error) {
+	rows, err := e.Query(ctx, target, fmt.Sprintf("SHOW GLOBAL VARIABLES LIKE %s", QuoteString(name)))
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("variable %s not found", name)
+	}
+	return rows[0]["Value"], nil
+}
+
+// 查询全局状态变量
+func GetGlobalStatus(ctx context.Context, e SQLExecutor, target Target, name string) (string, error) {
+	rows, err := e.Query(ctx, target, fmt.Sprintf("SHOW GLOBAL STATUS LIKE %s", QuoteString(name)))
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("status variable %s not found", name)
+	}
+	return rows[0]["Value"], nil
+}
+
+// 查询实例已经执行的 gtid 集合
+func GetExecutedGTIDSet(ctx context.Context, e SQLExecutor, target Target) (string, error) {
+	value, err := GetGlobalVariable(ctx, e, target, "gtid_executed")
+	if err != nil {
+		return "", err
+	}
+	return normalizeGTIDSet(value), nil
+}
+
+// 等待实例执行完给定的 gtid 集合，超时返回 false
+func WaitForExecutedGTIDSet(ctx context.Context, e SQLExecutor, target Target, gtidSet string, timeout time.Duration) (bool, error) {
+	query := fmt.Sprintf("SELECT WAIT_FOR_EXECUTED_GTID_SET(%s, %d) AS timed_out", QuoteString(gtidSet), int(timeout.Seconds()))
+	rows, err := e.Query(ctx, target, query)
+	if err != nil {
+		return false, err
+	}
+	if len(rows) == 0 {
+		return false, fmt.Errorf("WAIT_FOR_EXECUTED_GTID_SET returned no rows")
+	}
+	return rows[0]["timed_out"] == "0", nil
+}
+
+// 插件是否已经安装并处于 ACTIVE 状态
+func IsPluginActive(ctx context.Context, e SQLExecutor, target Target, name string) (bool, error) {
+	query := fmt.Sprintf("SELECT PLUGIN_STATUS FROM information_schema.PLUGINS WHERE PLUGIN_NAME = %s", QuoteString(name))
+	rows, err := e.Query(ctx, target, query)
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0 && rows[0]["PLUGIN_STATUS"] == "ACTIVE", nil
+}
+
+// performance_schema.replication_group_members 中的一个成员
+type GroupMember struct {
+	Host  string
+	Port  int
+	State string
+	Role  string
+}
+
+// 查询实例看到的组复制成员，没有开启组复制时只有本实例一行 OFFLINE 或者为空
+func GetGroupMembers(ctx context.Context, e SQLExecutor, target Target) ([]GroupMember, error) {
+	rows, err := e.Query(ctx, target, "SELECT MEMBER_HOST, MEMBER_PORT, MEMBER_STATE, MEMBER_ROLE FROM performance_schema.replication_group_members")
+	if err != nil {
+		return nil, err
+	}
+	members := make([]GroupMember, 0, len(rows))
+	for _, row := range rows {
+		port, _ := strconv.Atoi(row["MEMBER_PORT"])
+		members = append(members, GroupMember{
+			Host:  row["MEMBER_HOST"],
+			Port:  port,
+			State: row["MEMBER_STATE"],
+			Role:  row["MEMBER_ROLE"],
+		})
+	}
+	return members, nil
+}
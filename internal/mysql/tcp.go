@@ -0,0 +1,205 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
+)
+
+// TCP 后端的超时和连接池配置
+type TCPOptions struct {
+	// 建立连接的超时时间
+	DialTimeout time.Duration
+	// 单次 Exec/Query 的超时时间
+	QueryTimeout time.Duration
+	// 每个实例最多保持的连接数
+	MaxOpenConns int
+	// 空闲连接的最长保留时间
+	ConnMaxIdleTime time.Duration
+	// 连接池的最长空闲时间，超过后整个连接池被回收，例如 pod 被删除、IP 不会再使用
+	PoolMaxIdleTime time.Duration
+}
+
+// 默认配置
+func DefaultTCPOptions() TCPOptions {
+	return TCPOptions{
+		DialTimeout:     5 * time.Second,
+		QueryTimeout:    60 * time.Second,
+		MaxOpenConns:    2,
+		ConnMaxIdleTime: time.Minute,
+		PoolMaxIdleTime: 10 * time.Minute,
+	}
+}
+
+// 连接池
+type pool struct {
+	password string
+	db       *sql.DB
+	lastUsed time.Time
+}
+
+// TCPExecutor 使用 go-sql-driver 直连 pod IP 执行 sql
+// 每个实例地址和账号共用一个连接池
+type TCPExecutor struct {
+	opts  TCPOptions
+	mu    sync.Mutex
+	pools map[string]*pool
+}
+
+var _ SQLExecutor = &TCPExecutor{}
+
+func NewTCPExecutor(opts TCPOptions) *TCPExecutor {
+	return &TCPExecutor{opts: opts, pools: map[string]*pool{}}
+}
+
+// 获取目标实例的连接池和它的 key，密码变化（例如轮换后）时重建
+// 顺便回收长时间没有使用的连接池
+func (e *TCPExecutor) getDB(target Target) (string, *sql.DB, error) {
+	host := target.host()
+	if host == "" {
+		return "", nil, fmt.Errorf("target has no host or pod IP")
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(target.port()))
+	key := target.User + "@" + addr
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for k, p := range e.pools {
+		if e.opts.PoolMaxIdleTime > 0 && now.Sub(p.lastUsed) > e.opts.PoolMaxIdleTime {
+			p.db.Close()
+			delete(e.pools, k)
+		}
+	}
+	if p, ok := e.pools[key]; ok {
+		if p.password == target.Password {
+			p.lastUsed = now
+			return key, p.db, nil
+		}
+		p.db.Close()
+		delete(e.pools, key)
+	}
+
+	cfg := driver.NewConfig()
+	cfg.User = target.User
+	cfg.Passwd = target.Password
+	cfg.Net = "tcp"
+	cfg.Addr = addr
+	cfg.Timeout = e.opts.DialTimeout
+	cfg.ReadTimeout = e.opts.QueryTimeout
+	cfg.WriteTimeout = e.opts.QueryTimeout
+
+	connector, err := driver.NewConnector(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(e.opts.MaxOpenConns)
+	db.SetMaxIdleConns(e.opts.MaxOpenConns)
+	db.SetConnMaxIdleTime(e.opts.ConnMaxIdleTime)
+	e.pools[key] = &pool{password: target.Password, db: db, lastUsed: now}
+	return key, db, nil
+}
+
+// 连不上实例时回收它的连接池，pod 重建后 IP 会变化，旧地址不会再使用
+// MySQL 返回的错误说明连接正常，不回收
+func (e *TCPExecutor) evictOnConnError(key string, db *sql.DB, err error) {
+	var mysqlErr *driver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if p, ok := e.pools[key]; ok && p.db == db {
+		p.db.Close()
+		delete(e.pools, key)
+	}
+}
+
+func (e *TCPExecutor) Exec(ctx context.Context, target Target, statements ...string) error {
+	key, db, err := e.getDB(target)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.opts.QueryTimeout)
+	defer cancel()
+
+	// 多条语句需要在同一个会话中执行，例如 SET GTID_NEXT 之后的 BEGIN/COMMIT
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		e.evictOnConnError(key, db, err)
+		return err
+	}
+	defer conn.Close()
+
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			// 出错时会话可能还留着 SQL_LOG_BIN = 0、GTID_NEXT 等设置，丢弃连接，不放回连接池
+			conn.Raw(func(any) error { return sqldriver.ErrBadConn })
+			e.evictOnConnError(key, db, err)
+			return fmt.Errorf("%s: %v", statement, err)
+		}
+	}
+	return nil
+}
+
+func (e *TCPExecutor) Query(ctx context.Context, target Target, query string) ([]Row, error) {
+	key, db, err := e.getDB(target)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.opts.QueryTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		e.evictOnConnError(key, db, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Row{}
+	values := make([]sql.RawBytes, len(columns))
+	scanArgs := make([]any, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, err
+		}
+		row := Row{}
+		for i, column := range columns {
+			row[column] = string(values[i])
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func (e *TCPExecutor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for key, p := range e.pools {
+		p.db.Close()
+		delete(e.pools, key)
+	}
+	return nil
+}